@@ -0,0 +1,101 @@
+package mafmt
+
+import (
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Capture matches the same addresses as p, and names the components it
+// matches so that MatchCapture can extract their values.
+func Capture(name string, p Pattern) Pattern {
+	return &capture{name: name, inner: p}
+}
+
+// MatchCapture reports whether root matches a and, if it does, returns the
+// values of the components matched by each Capture in root, keyed by name.
+// A capture of a single component has that component's value, such as "443"
+// for a tcp component, and a capture of several has the textual form of
+// those components, such as "/ip4/1.2.3.4/tcp/443". Captures of nothing,
+// such as an Optional that was left out, are absent from the result. When a
+// name is captured more than once, as inside a repetition, the last capture
+// wins.
+func MatchCapture(root Pattern, a ma.Multiaddr) (map[string]string, bool) {
+	f := &failures{all: components(a)}
+	if !root.match(f.all, f, isEmpty) {
+		return nil, false
+	}
+	values := make(map[string]string)
+	for _, c := range f.captures {
+		if len(c.pcs) == 1 {
+			values[c.name] = c.pcs[0].decode().Value()
+			continue
+		}
+		var cs []ma.Multiaddr
+		for _, pc := range c.pcs {
+			cs = append(cs, pc.decode())
+		}
+		values[c.name] = ma.Join(cs...).String()
+	}
+	return values, true
+}
+
+// captured is the run of components matched by a capture.
+type captured struct {
+	name string
+	pcs  []component
+}
+
+type capture struct {
+	name  string
+	inner Pattern
+}
+
+func (p *capture) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *capture) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *capture) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *capture) Equal(other Pattern) bool {
+	o, ok := other.(*capture)
+	return ok && o.name == p.name && o.inner.Equal(p.inner)
+}
+
+func (p *capture) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *capture) Example() (ma.Multiaddr, error) {
+	return p.inner.Example()
+}
+
+func (p *capture) partialMatch(pcs []component) (bool, []component) {
+	return p.inner.partialMatch(pcs)
+}
+
+func (p *capture) match(pcs []component, f *failures, next func([]component) bool) bool {
+	if f == nil {
+		return p.inner.match(pcs, f, next)
+	}
+	return p.inner.match(pcs, f, func(rem []component) bool {
+		n := len(f.captures)
+		if len(rem) < len(pcs) {
+			f.captures = append(f.captures, captured{name: p.name, pcs: pcs[:len(pcs)-len(rem)]})
+		}
+		if next(rem) {
+			return true
+		}
+		// Forget the capture when backtracking out of it.
+		f.captures = f.captures[:n]
+		return false
+	})
+}
+
+func (p *capture) String() string {
+	return format(p, protocolName)
+}
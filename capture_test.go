@@ -0,0 +1,62 @@
+package mafmt
+
+import (
+	"fmt"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMatchCapture(t *testing.T) {
+	p := And(Capture("host", IP), Capture("port", Base(ma.P_TCP)), Optional(Capture("security", TLS)))
+
+	for s, expected := range map[string]map[string]string{
+		"/ip4/1.2.3.4/tcp/443":     {"host": "1.2.3.4", "port": "443"},
+		"/ip6/::1/tcp/443/tls":     {"host": "::1", "port": "443", "security": ""},
+		"/dns/example.com/tcp/443": nil,
+	} {
+		values, ok := MatchCapture(p, ma.StringCast(s))
+		if ok != (expected != nil) || fmt.Sprint(values) != fmt.Sprint(expected) {
+			t.Errorf("expected %v from %s, got %v %t", expected, s, values, ok)
+		}
+	}
+
+	// Captures spanning several components have their textual form.
+	values, ok := MatchCapture(And(Capture("addr", TCP), Base(ma.P_HTTP)), ma.StringCast("/ip4/1.2.3.4/tcp/80/http"))
+	if !ok || values["addr"] != "/ip4/1.2.3.4/tcp/80" {
+		t.Fatalf("unexpected captures %v", values)
+	}
+
+	// The last of repeated captures wins, and captures from alternatives
+	// that were backtracked out of are dropped.
+	hops := And(OneOrMore(Capture("peer", Base(ma.P_P2P))), Base(ma.P_P2P))
+	values, ok = MatchCapture(hops, ma.StringCast(
+		"/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p/12D3KooWQF6Q3i1QkziJQ9mkNNcyFD8GPQz6R6oEvT75wgsVXm4v/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"))
+	if !ok || values["peer"] != "12D3KooWQF6Q3i1QkziJQ9mkNNcyFD8GPQz6R6oEvT75wgsVXm4v" {
+		t.Fatalf("unexpected captures %v", values)
+	}
+
+	if s := p.String(); s != "host:{ip4|ip6zone?/ip6}/port:tcp/(security:tls)?" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/443"})
+
+	assertRoundTrip(t, p)
+}
+
+func TestMatchCaptureXOr(t *testing.T) {
+	// The ambiguous XOr fails, so the capture after it belongs to a branch
+	// that did not match.
+	ambiguous := And(XOr(Base(ma.P_IP4), Base(ma.P_IP4)), Capture("stale", Base(ma.P_TCP)))
+	p := Or(ambiguous, TCP4)
+	caps, ok := MatchCapture(p, ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if !ok || len(caps) != 0 {
+		t.Fatalf("expected a match with no captures, got %v, %v", caps, ok)
+	}
+
+	single := And(XOr(Base(ma.P_IP4), Base(ma.P_IP6)), Capture("port", Base(ma.P_TCP)))
+	caps, ok = MatchCapture(single, ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if !ok || len(caps) != 1 || caps["port"] != "80" {
+		t.Fatalf("unexpected captures %v, %v", caps, ok)
+	}
+}
@@ -0,0 +1,235 @@
+package mafmt
+
+import (
+	"fmt"
+	"slices"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Matcher is a compiled form of a pattern, built by Compile.
+type Matcher interface {
+	// Match reports whether the pattern the matcher was compiled from
+	// matches a.
+	Match(a ma.Multiaddr) bool
+}
+
+// maxCompiledStates bounds the size of the automata built by Compile, so
+// that pathological patterns fall back to p.Matches rather than taking too
+// long to compile.
+const maxCompiledStates = 1024
+
+// Compile builds a Matcher that gives the same results as p.Matches but
+// matches without allocating. Patterns built from Base, And, Or, Optional
+// and the repetition constructors are compiled to a deterministic automaton
+// over protocol codes; any other pattern, such as one constraining component
+// values, is matched with p.Matches instead.
+func Compile(p Pattern) Matcher {
+	var n nfa
+	start, err := n.build(p, n.add(nfaState{code: nfaAccept}))
+	if err != nil {
+		return patternMatcher{p}
+	}
+	d, err := n.determinize(start)
+	if err != nil {
+		return patternMatcher{p}
+	}
+	return d
+}
+
+type patternMatcher struct {
+	p Pattern
+}
+
+func (m patternMatcher) Match(a ma.Multiaddr) bool {
+	return m.p.Matches(a)
+}
+
+// Codes of nfaStates that do not consume a component.
+const (
+	nfaSplit  = -1
+	nfaAccept = -2
+	nfaDead   = -3
+)
+
+// nfaState consumes a component of protocol code and moves to out, or for
+// a split, moves to both out and out1 without consuming anything.
+type nfaState struct {
+	code      int
+	out, out1 int
+}
+
+type nfa struct {
+	states []nfaState
+}
+
+func (n *nfa) add(s nfaState) int {
+	n.states = append(n.states, s)
+	return len(n.states) - 1
+}
+
+func (n *nfa) split(out, out1 int) int {
+	return n.add(nfaState{code: nfaSplit, out: out, out1: out1})
+}
+
+// build adds the states matching p followed by the states starting at next,
+// and returns the first of them.
+func (n *nfa) build(p Pattern, next int) (int, error) {
+	if len(n.states) > maxCompiledStates {
+		return 0, fmt.Errorf("pattern is too large to compile")
+	}
+	switch p := p.(type) {
+	case Base:
+		return n.add(nfaState{code: int(p), out: next}), nil
+	case *anyBase:
+		if p.except {
+			break
+		}
+		start := n.add(nfaState{code: nfaDead})
+		for _, c := range p.codes {
+			start = n.split(n.add(nfaState{code: c, out: next}), start)
+		}
+		return start, nil
+	case *pattern:
+		switch p.Op {
+		case OpAnd:
+			var err error
+			for i := len(p.Args) - 1; i >= 0 && err == nil; i-- {
+				next, err = n.build(p.Args[i], next)
+			}
+			return next, err
+		case OpOr:
+			if len(p.Args) == 0 {
+				return n.add(nfaState{code: nfaDead}), nil
+			}
+			start, err := n.build(p.Args[len(p.Args)-1], next)
+			for i := len(p.Args) - 2; i >= 0 && err == nil; i-- {
+				var alt int
+				alt, err = n.build(p.Args[i], next)
+				start = n.split(alt, start)
+			}
+			return start, err
+		case OpOptional:
+			start, err := n.build(p.Args[0], next)
+			return n.split(start, next), err
+		case OpRepeat:
+			return n.buildRepeat(p, next)
+		}
+	}
+	return 0, fmt.Errorf("cannot compile %s", p)
+}
+
+func (n *nfa) buildRepeat(p *pattern, next int) (int, error) {
+	tail := next
+	if p.Max < 0 {
+		// Loop back through a split that is patched once the body exists.
+		loop := n.split(0, next)
+		body, err := n.build(p.Args[0], loop)
+		if err != nil {
+			return 0, err
+		}
+		n.states[loop].out = body
+		tail = loop
+	} else {
+		for i := p.Min; i < p.Max; i++ {
+			body, err := n.build(p.Args[0], tail)
+			if err != nil {
+				return 0, err
+			}
+			tail = n.split(body, next)
+		}
+	}
+	for i := 0; i < p.Min; i++ {
+		var err error
+		if tail, err = n.build(p.Args[0], tail); err != nil {
+			return 0, err
+		}
+	}
+	return tail, nil
+}
+
+// closure adds to set every state reachable from s without consuming a
+// component.
+func (n *nfa) closure(set map[int]bool, s int) {
+	if set[s] {
+		return
+	}
+	set[s] = true
+	if st := n.states[s]; st.code == nfaSplit {
+		n.closure(set, st.out)
+		n.closure(set, st.out1)
+	}
+}
+
+// determinize converts the automaton starting at start into a dfa by subset
+// construction.
+func (n *nfa) determinize(start int) (dfa, error) {
+	var d dfa
+	index := make(map[string]int)
+	var sets [][]int
+
+	addSet := func(set map[int]bool) int {
+		var states []int
+		accept := false
+		for s := range set {
+			switch n.states[s].code {
+			case nfaSplit, nfaDead:
+			case nfaAccept:
+				accept = true
+			default:
+				states = append(states, s)
+			}
+		}
+		slices.Sort(states)
+		key := fmt.Sprint(accept, states)
+		if i, ok := index[key]; ok {
+			return i
+		}
+		index[key] = len(d)
+		d = append(d, dfaState{accept: accept})
+		sets = append(sets, states)
+		return len(d) - 1
+	}
+
+	first := make(map[int]bool)
+	n.closure(first, start)
+	addSet(first)
+	for i := 0; i < len(d); i++ {
+		if len(d) > maxCompiledStates {
+			return nil, fmt.Errorf("pattern is too large to compile")
+		}
+		moves := make(map[int]map[int]bool)
+		for _, s := range sets[i] {
+			st := n.states[s]
+			if moves[st.code] == nil {
+				moves[st.code] = make(map[int]bool)
+			}
+			n.closure(moves[st.code], st.out)
+		}
+		for code, set := range moves {
+			if d[i].next == nil {
+				d[i].next = make(map[int]int)
+			}
+			d[i].next[code] = addSet(set)
+		}
+	}
+	return d, nil
+}
+
+// dfa is a deterministic automaton over protocol codes, starting in its
+// first state.
+type dfa []dfaState
+
+type dfaState struct {
+	next   map[int]int
+	accept bool
+}
+
+func (d dfa) Match(a ma.Multiaddr) bool {
+	state, ok := 0, true
+	ma.ForEach(a, func(c ma.Component) bool {
+		state, ok = d[state].next[c.Protocol().Code]
+		return ok
+	})
+	return ok && d[state].accept
+}
@@ -0,0 +1,111 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// compileTestPatterns are checked for equivalence with their compiled form.
+func compileTestPatterns() []Pattern {
+	ps := []Pattern{
+		Reliable,
+		TorReliable,
+		P2PCircuit,
+		Or(),
+		And(),
+		Repeat(Base(ma.P_CIRCUIT), 1, 3),
+		ZeroOrMore(Optional(Base(ma.P_P2P))),
+		And(Repeat(Base(ma.P_CIRCUIT), 1, 3), Base(ma.P_CIRCUIT)),
+		And(IP, Not(Base(ma.P_TCP))),
+		And(IPInCIDR("10.0.0.0/8"), Base(ma.P_TCP)),
+		And(AnyBase(ma.P_IP4, ma.P_IP6, ma.P_DNS), AnyBase(ma.P_TCP, ma.P_UDP)),
+		AnyBase(),
+		And(TCP, AnyBaseExcept(ma.P_TLS)),
+	}
+	for _, tc := range TestVectors {
+		ps = append(ps, tc.Pattern)
+	}
+	return ps
+}
+
+func TestCompile(t *testing.T) {
+	var addrs []ma.Multiaddr
+	for _, tc := range TestVectors {
+		for _, s := range append(append([]string(nil), tc.Good...), tc.Bad...) {
+			if a, err := ma.NewMultiaddr(s); err == nil {
+				addrs = append(addrs, a)
+			}
+		}
+	}
+	addrs = append(addrs, ma.Join(), ma.StringCast("/p2p-circuit/p2p-circuit/p2p-circuit/p2p-circuit"))
+
+	for _, p := range compileTestPatterns() {
+		m := Compile(p)
+		for _, a := range addrs {
+			if m.Match(a) != p.Matches(a) {
+				t.Errorf("compiled %s disagrees with Matches on %s", p, a)
+			}
+		}
+	}
+
+	if _, ok := Compile(TCP).(dfa); !ok {
+		t.Error("expected TCP to compile to an automaton")
+	}
+	if _, ok := Compile(And(IP, Not(Base(ma.P_TCP)))).(patternMatcher); !ok {
+		t.Error("expected a negation to fall back to Matches")
+	}
+}
+
+func TestCompileAllocs(t *testing.T) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/80")
+	for _, p := range []Pattern{TCP, And(AnyBase(ma.P_IP4, ma.P_IP6), Base(ma.P_TCP))} {
+		m := Compile(p)
+		if n := testing.AllocsPerRun(100, func() { m.Match(a) }); n != 0 {
+			t.Fatalf("expected no allocations matching %s, got %v", p, n)
+		}
+	}
+}
+
+func FuzzCompile(f *testing.F) {
+	for _, tc := range TestVectors {
+		for _, s := range tc.Good {
+			if a, err := ma.NewMultiaddr(s); err == nil {
+				f.Add(a.Bytes())
+			}
+		}
+	}
+	ps := compileTestPatterns()
+	var ms []Matcher
+	for _, p := range ps {
+		ms = append(ms, Compile(p))
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		a, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			return
+		}
+		for i, p := range ps {
+			if ms[i].Match(a) != p.Matches(a) {
+				t.Fatalf("compiled %s disagrees with Matches on %s", p, a)
+			}
+		}
+	})
+}
+
+func BenchmarkMatches(b *testing.B) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/80")
+	b.Run("Matches", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			TCP.Matches(a)
+		}
+	})
+	b.Run("Compiled", func(b *testing.B) {
+		m := Compile(TCP)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			m.Match(a)
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package mafmt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// failures tracks the furthest position into an address at which matching
+// failed, and what the pattern expected to find there. It also holds the
+// captures made so far by the match in progress, for MatchCapture.
+//
+// All methods are no-ops on a nil *failures, so matching code can record
+// failures unconditionally and Matches pays nothing for them.
+type failures struct {
+	all      []component
+	pos      int
+	expected []string
+	captures []captured
+}
+
+// expect records that what was expected at the start of pcs, which must be a
+// suffix of f.all.
+func (f *failures) expect(pcs []component, what string) {
+	if f == nil {
+		return
+	}
+
+	pos := len(f.all) - len(pcs)
+	switch {
+	case pos < f.pos:
+		return
+	case pos > f.pos:
+		f.pos = pos
+		f.expected = f.expected[:0]
+	}
+	for _, e := range f.expected {
+		if e == what {
+			return
+		}
+	}
+	f.expected = append(f.expected, what)
+}
+
+func (f *failures) err() error {
+	if len(f.expected) == 0 {
+		// Nothing was tried, as for an empty Or.
+		return errors.New("pattern matches no address")
+	}
+	var b strings.Builder
+	b.WriteString("expected ")
+	if len(f.expected) > 1 {
+		b.WriteString("one of ")
+	}
+	b.WriteString(strings.Join(f.expected, ", "))
+	if f.pos > 0 {
+		b.WriteString(" after ")
+		b.WriteString(f.all[f.pos-1].name())
+	}
+	if f.pos < len(f.all) {
+		fmt.Fprintf(&b, " but got %s at position %d", f.all[f.pos].name(), f.pos)
+	} else {
+		fmt.Fprintf(&b, " but reached the end of the address at position %d", f.pos)
+	}
+	return errors.New(b.String())
+}
+
+// matchErr implements Pattern.MatchErr on top of the pattern's matcher.
+func matchErr(p Pattern, a ma.Multiaddr) error {
+	f := &failures{all: components(a)}
+	if p.match(f.all, f, func(rem []component) bool {
+		if len(rem) != 0 {
+			f.expect(rem, "end of address")
+			return false
+		}
+		return true
+	}) {
+		return nil
+	}
+	return f.err()
+}
@@ -0,0 +1,60 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMatchErr(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Addr    string
+		Err     string
+	}{
+		{TCP, "/ip4/1.2.3.4/tcp/80", ""},
+		{TCP, "/ip4/1.2.3.4/udp/80", "expected tcp after ip4 but got udp at position 1"},
+		{TCP, "/udp/80", "expected one of ip4, ip6zone, ip6, dns, dns4, dns6 but got udp at position 0"},
+		{TCP, "/ip4/1.2.3.4", "expected tcp after ip4 but reached the end of the address at position 1"},
+		{TCP, "/ip4/1.2.3.4/tcp/80/http", "expected end of address after tcp but got http at position 2"},
+		{QUIC, "/ip4/1.2.3.4/udp/80/ws", "expected quic after udp but got ws at position 2"},
+		{Reliable, "/ip4/1.2.3.4/udp/80/ws", "expected one of utp, quic, quic-v1 after udp but got ws at position 2"},
+		{Base(ma.P_IP6), "/ip4/1.2.3.4", "expected ip6 but got ip4 at position 0"},
+		{Not(Base(ma.P_IP4)), "/ip4/1.2.3.4", "expected !ip4 but got ip4 at position 0"},
+		{And(TCP, Optional(Base(ma.P_TLS)), Base(ma.P_WS)), "/ip4/1.2.3.4/tcp/80/http", "expected one of tls, ws after tcp but got http at position 2"},
+	} {
+		addr, err := ma.NewMultiaddr(tc.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = tc.Pattern.MatchErr(addr)
+		if tc.Err == "" {
+			if err != nil {
+				t.Errorf("%s against %s: unexpected error: %s", tc.Pattern, addr, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%s against %s: expected error %q", tc.Pattern, addr, tc.Err)
+		} else if err.Error() != tc.Err {
+			t.Errorf("%s against %s: expected error %q, got %q", tc.Pattern, addr, tc.Err, err)
+		}
+	}
+}
+
+func TestMatchErrAgreesWithMatches(t *testing.T) {
+	for name, tc := range TestVectors {
+		for _, other := range TestVectors {
+			for _, s := range append(other.Good, other.Bad...) {
+				addr, err := ma.NewMultiaddr(s)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if matched, err := tc.Pattern.Matches(addr), tc.Pattern.MatchErr(addr); matched != (err == nil) {
+					t.Errorf("%s against %s: Matches returned %t but MatchErr returned %v", name, addr, matched, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package mafmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ToDOT renders the pattern tree rooted at p as a Graphviz digraph, for
+// visualizing how a pattern is built. Operators and wrappers such as Prefix
+// are nodes labeled with their names, with an edge to each of their
+// children, and leaves are labeled as they are in String, so that Base
+// leaves show their protocol names. Shared sub-patterns appear once for each
+// place they are used.
+func ToDOT(p Pattern) string {
+	var b strings.Builder
+	b.WriteString("digraph pattern {\n")
+	n := 0
+	var visit func(p Pattern) int
+	visit = func(p Pattern) int {
+		id := n
+		n++
+		fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n", id, dotEscaper.Replace(dotLabel(p)))
+		for _, child := range children(p) {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", id, visit(child))
+		}
+		return id
+	}
+	visit(p)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotEscaper escapes labels for DOT's quoted strings, in which only quotes
+// and backslashes are special.
+var dotEscaper = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+
+// dotLabel labels the node for p in ToDOT.
+func dotLabel(p Pattern) string {
+	switch p := p.(type) {
+	case *pattern:
+		switch {
+		case p.Op != OpRepeat:
+			return p.Op.String()
+		case p.Max < 0:
+			return fmt.Sprintf("repeat{%d,}", p.Min)
+		default:
+			return fmt.Sprintf("repeat{%d,%d}", p.Min, p.Max)
+		}
+	case Composite:
+		return p.Operator().String()
+	case *prefix:
+		return "prefix"
+	case *suffix:
+		return "suffix"
+	case *capture:
+		return "capture " + p.name
+	case *ignoring:
+		return "ignoring " + p.ignoredNames(protocolName, "|")
+	case *head:
+		return fmt.Sprintf("head %d", p.n)
+	case *hostIsIP:
+		return "hostip"
+	case *maxComponents:
+		return fmt.Sprintf("maxcomponents %d", p.n)
+	}
+	return p.String()
+}
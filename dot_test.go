@@ -0,0 +1,44 @@
+package mafmt
+
+import (
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestToDOT(t *testing.T) {
+	p := And(Or(Base(ma.P_IP4), Base(ma.P_IP6)), Base(ma.P_TCP), ZeroOrMore(Base(ma.P_P2P)))
+	expected := `digraph pattern {
+	n0 [label="and"];
+	n1 [label="or"];
+	n2 [label="ip4"];
+	n1 -> n2;
+	n3 [label="ip6"];
+	n1 -> n3;
+	n0 -> n1;
+	n4 [label="tcp"];
+	n0 -> n4;
+	n5 [label="repeat{0,}"];
+	n6 [label="p2p"];
+	n5 -> n6;
+	n0 -> n5;
+}
+`
+	if s := ToDOT(p); s != expected {
+		t.Fatalf("unexpected output:\n%s", s)
+	}
+
+	s := ToDOT(Capture("port", Prefix(PortInRange(ma.P_TCP, 1, 1024))))
+	for _, want := range []string{`n0 [label="capture port"]`, `n1 [label="prefix"]`, `n2 [label="tcp=1-1024"]`, "n0 -> n1;", "n1 -> n2;"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in output:\n%s", want, s)
+		}
+	}
+
+	// Only quotes and backslashes are escaped, unlike in Go strings.
+	s = ToDOT(Capture(`say "hé\n"`, Base(ma.P_TCP)))
+	if want := `n0 [label="capture say \"hé\\n\""]`; !strings.Contains(s, want) {
+		t.Errorf("expected %q in output:\n%s", want, s)
+	}
+}
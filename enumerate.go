@@ -0,0 +1,294 @@
+package mafmt
+
+import (
+	"fmt"
+	"slices"
+)
+
+// maxEnumerated bounds the number of protocol sequences Enumerate builds,
+// counting the partial sequences of an And and those it drops as
+// duplicates, so that patterns such as a bounded Repeat of an Or, whose
+// sequences multiply with every repetition, fail rather than exhaust time
+// and memory.
+const maxEnumerated = 4096
+
+// Enumerate returns every sequence of protocol codes accepted by p, in the
+// order the alternatives of p are written. Patterns that also constrain
+// component values, such as IPInCIDR, contribute their protocol codes, so
+// not every address with an enumerated sequence need match. A sequence
+// through an XOr is only listed if exactly one of its alternatives matches
+// it, counted as Matches counts them, in the context of the whole of p. It
+// returns an error if p accepts infinitely many sequences, as for unbounded
+// repetitions, Prefix, Suffix and Head, if the accepted sequences cannot be
+// listed, as for Not, or if there are too many of them to list.
+func Enumerate(p Pattern) ([][]int, error) {
+	seqs, err := new(enumeration).enumerate(p)
+	if err != nil || !hasXOr(p) {
+		return seqs, err
+	}
+
+	// Whether an XOr matches depends on what follows it, so its
+	// alternatives are enumerated like those of an Or, and the sequences
+	// are then matched against p.
+	bare := protocolsOnly(p)
+	kept := seqs[:0]
+	for _, seq := range seqs {
+		pcs := make([]component, len(seq))
+		for i, code := range seq {
+			pcs[i].Code = code
+		}
+		if bare.match(pcs, nil, isEmpty) {
+			kept = append(kept, seq)
+		}
+	}
+	return kept, nil
+}
+
+// protocolsOnly copies the pattern tree rooted at p, replacing the patterns
+// that constrain component values with the protocols they accept, so that
+// the copy can match bare protocols, as Enumerate lists them.
+func protocolsOnly(p Pattern) Pattern {
+	return mapTree(p, func(_, p Pattern) Pattern {
+		switch p := p.(type) {
+		case *ipInCIDR:
+			return Base(p.code)
+		case *portRange:
+			return Base(p.code)
+		case *baseValue:
+			return Base(p.code)
+		case *basePredicate:
+			return Base(p.code)
+		case *dnsName:
+			return AnyBase(dnsCodes...)
+		case *hostIsIP:
+			return p.inner
+		}
+		return p
+	})
+}
+
+// enumeration counts the sequences built while enumerating a pattern.
+type enumeration struct {
+	n int
+}
+
+// built records that n more sequences were built.
+func (e *enumeration) built(n int) error {
+	if e.n += n; e.n > maxEnumerated {
+		return fmt.Errorf("pattern accepts too many protocol sequences to enumerate, more than %d", maxEnumerated)
+	}
+	return nil
+}
+
+func (e *enumeration) enumerate(p Pattern) ([][]int, error) {
+	switch p := p.(type) {
+	case Base:
+		return [][]int{{int(p)}}, e.built(1)
+	case *ipInCIDR:
+		return [][]int{{p.code}}, e.built(1)
+	case *portRange:
+		return [][]int{{p.code}}, e.built(1)
+	case *baseValue:
+		return [][]int{{p.code}}, e.built(1)
+	case *basePredicate:
+		return [][]int{{p.code}}, e.built(1)
+	case *dnsName:
+		var seqs [][]int
+		for _, code := range dnsCodes {
+			seqs = append(seqs, []int{code})
+		}
+		return seqs, e.built(len(seqs))
+	case *capture:
+		return e.enumerate(p.inner)
+	case *maxComponents:
+		sub, err := e.enumerate(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		var seqs [][]int
+		for _, seq := range sub {
+			if len(seq) <= p.n {
+				seqs = append(seqs, seq)
+			}
+		}
+		return seqs, nil
+	case *hostIsIP:
+		sub, err := e.enumerate(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		var seqs [][]int
+		for _, seq := range sub {
+			if len(seq) > 0 && slices.Contains(hostCodes, seq[0]) {
+				seqs = append(seqs, seq)
+			}
+		}
+		return seqs, nil
+	case *anyBase:
+		if p.except {
+			return nil, fmt.Errorf("cannot enumerate the protocols matched by %s", p)
+		}
+		var seqs [][]int
+		for _, c := range p.codes {
+			seqs = appendNew(seqs, []int{c})
+		}
+		return seqs, e.built(len(p.codes))
+	case *pattern:
+		switch p.Op {
+		case OpOr, OpXOr:
+			var seqs [][]int
+			for _, a := range p.Args {
+				sub, err := e.enumerate(a)
+				if err != nil {
+					return nil, err
+				}
+				seqs = appendNew(seqs, sub...)
+			}
+			return seqs, nil
+		case OpAnd:
+			return e.enumerateSeq(p.Args)
+		case OpOptional:
+			sub, err := e.enumerate(p.Args[0])
+			if err != nil {
+				return nil, err
+			}
+			return appendNew([][]int{{}}, sub...), e.built(1)
+		case OpRepeat:
+			if p.Max < 0 {
+				return nil, fmt.Errorf("cannot enumerate unbounded repetition %s", p)
+			}
+			var seqs [][]int
+			for n := p.Min; n <= p.Max; n++ {
+				ps := make([]Pattern, n)
+				for i := range ps {
+					ps[i] = p.Args[0]
+				}
+				sub, err := e.enumerateSeq(ps)
+				if err != nil {
+					return nil, err
+				}
+				seqs = appendNew(seqs, sub...)
+			}
+			return seqs, nil
+		case OpAnyOrder:
+			var seqs [][]int
+			err := permute(p.Args, func(ps []Pattern) error {
+				if err := e.built(1); err != nil {
+					return err
+				}
+				sub, err := e.enumerateSeq(ps)
+				seqs = appendNew(seqs, sub...)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			return seqs, nil
+		}
+	}
+	return nil, fmt.Errorf("cannot enumerate %s", p)
+}
+
+// enumerateSeq returns every concatenation of the sequences accepted by
+// each of ps in turn.
+func (e *enumeration) enumerateSeq(ps []Pattern) ([][]int, error) {
+	seqs := [][]int{{}}
+	for _, p := range ps {
+		sub, err := e.enumerate(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := e.built(len(seqs) * len(sub)); err != nil {
+			return nil, err
+		}
+		var next [][]int
+		for _, prefix := range seqs {
+			for _, suffix := range sub {
+				next = appendNew(next, slices.Concat(prefix, suffix))
+			}
+		}
+		seqs = next
+	}
+	return seqs, nil
+}
+
+// permute calls f with every ordering of ps, stopping at the first error.
+func permute(ps []Pattern, f func([]Pattern) error) error {
+	if len(ps) <= 1 {
+		return f(ps)
+	}
+	for i := range ps {
+		rest := slices.Concat(ps[:i], ps[i+1:])
+		err := permute(rest, func(perm []Pattern) error {
+			return f(append([]Pattern{ps[i]}, perm...))
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendNew appends each of seqs to to, unless it is already present.
+func appendNew(to [][]int, seqs ...[]int) [][]int {
+	for _, seq := range seqs {
+		if !slices.ContainsFunc(to, func(s []int) bool { return slices.Equal(s, seq) }) {
+			to = append(to, seq)
+		}
+	}
+	return to
+}
+
+// Overlaps reports whether some sequence of protocol codes is accepted by
+// both a and b, as enumerated by Enumerate. Values are not considered, so
+// IPInCIDR("10.0.0.0/8") overlaps IPInCIDR("192.168.0.0/16"). It returns an
+// error when Enumerate fails for either pattern.
+func Overlaps(a, b Pattern) (bool, error) {
+	as, err := Enumerate(a)
+	if err != nil {
+		return false, err
+	}
+	bs, err := Enumerate(b)
+	if err != nil {
+		return false, err
+	}
+	for _, seq := range as {
+		if slices.ContainsFunc(bs, func(s []int) bool { return slices.Equal(s, seq) }) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Subsumes reports whether a matches every address b matches. It decides
+// this by enumerating the protocol sequences b accepts, so it returns an
+// error when Enumerate fails for b, and when a constrains component values,
+// which cannot be checked from protocols alone.
+func Subsumes(a, b Pattern) (bool, error) {
+	valued := false
+	Walk(a, func(p Pattern) bool {
+		switch p.(type) {
+		case *ipInCIDR, *portRange, *baseValue, *basePredicate, *dnsName, *hostIsIP:
+			valued = true
+		}
+		return !valued
+	})
+	if valued {
+		return false, fmt.Errorf("cannot decide subsumption by %s, which constrains component values", a)
+	}
+
+	seqs, err := Enumerate(b)
+	if err != nil {
+		return false, err
+	}
+	for _, seq := range seqs {
+		pcs := make([]component, len(seq))
+		for i, code := range seq {
+			pcs[i].Code = code
+		}
+		if !a.match(pcs, nil, isEmpty) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,127 @@
+package mafmt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestEnumerate(t *testing.T) {
+	var expected [][]int
+	for _, transport := range [][]int{{ma.P_TCP}, {ma.P_UDP, ma.P_UTP}, {ma.P_UDP, ma.P_QUIC}, {ma.P_UDP, ma.P_QUIC_V1}} {
+		for _, host := range [][]int{{ma.P_IP4}, {ma.P_IP6}, {ma.P_IP6ZONE, ma.P_IP6}, {ma.P_DNS}, {ma.P_DNS4}, {ma.P_DNS6}} {
+			expected = append(expected, slices.Concat(host, transport))
+		}
+	}
+	seqs, err := Enumerate(Reliable)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(seqs) != fmt.Sprint(expected) {
+		t.Fatalf("expected %v, got %v", expected, seqs)
+	}
+
+	for p, expected := range map[Pattern][][]int{
+		Optional(Base(ma.P_TLS)):                      {{}, {ma.P_TLS}},
+		Repeat(Base(ma.P_CIRCUIT), 1, 2):              {{ma.P_CIRCUIT}, {ma.P_CIRCUIT, ma.P_CIRCUIT}},
+		AnyOrder(Base(ma.P_P2P), Base(ma.P_CERTHASH)): {{ma.P_P2P, ma.P_CERTHASH}, {ma.P_CERTHASH, ma.P_P2P}},
+		Or(Base(ma.P_TCP), Base(ma.P_TCP)):            {{ma.P_TCP}},
+		Or():                                          nil,
+		XOr(Base(ma.P_TCP), Base(ma.P_UDP)):           {{ma.P_TCP}, {ma.P_UDP}},
+		XOr(Or(Base(ma.P_TCP), Base(ma.P_UDP)), Or(Base(ma.P_UDP), Base(ma.P_QUIC))): {{ma.P_TCP}, {ma.P_QUIC}},
+	} {
+		seqs, err := Enumerate(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.EqualFunc(seqs, expected, slices.Equal[[]int]) {
+			t.Errorf("expected %s to enumerate %v, got %v", p, expected, seqs)
+		}
+	}
+
+	for _, p := range []Pattern{WebTransport, Not(TCP), Prefix(TCP)} {
+		if _, err := Enumerate(p); err == nil {
+			t.Errorf("expected enumerating %s to fail", p)
+		}
+	}
+
+	// Bounded patterns whose sequences multiply fail instead of listing them.
+	transports := Or(Base(ma.P_TCP), Base(ma.P_UDP), Base(ma.P_QUIC), Base(ma.P_WS), Base(ma.P_TLS))
+	for _, p := range []Pattern{
+		Repeat(transports, 0, 50),
+		AnyOrder(transports, transports, transports, transports, transports, transports, transports, transports),
+	} {
+		if _, err := Enumerate(p); err == nil || !strings.Contains(err.Error(), "too many protocol sequences") {
+			t.Errorf("expected enumerating %s to fail for its size, got %v", p, err)
+		}
+	}
+}
+
+func TestSubsumes(t *testing.T) {
+	for _, tc := range []struct {
+		A, B     Pattern
+		Subsumes bool
+	}{
+		{Reliable, TCP, true},
+		{TCP, Reliable, false},
+		{TCP, TCP, true},
+		{Prefix(TCP), HTTP, false},
+		{Prefix(TCP), And(TCP, Base(ma.P_HTTP)), true},
+		{Reliable, And(IPInCIDR("10.0.0.0/8"), Base(ma.P_TCP)), true},
+		{TCP, Or(), true},
+	} {
+		ok, err := Subsumes(tc.A, tc.B)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.Subsumes {
+			t.Errorf("expected Subsumes(%s, %s) to be %t", tc.A, tc.B, tc.Subsumes)
+		}
+	}
+
+	if _, err := Subsumes(Reliable, WebTransport); err == nil {
+		t.Error("expected an error for an unbounded repetition")
+	}
+	if _, err := Subsumes(And(IPInCIDR("10.0.0.0/8"), Base(ma.P_TCP)), TCP); err == nil {
+		t.Error("expected an error for a value constraint")
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	for _, tc := range []struct {
+		A, B     Pattern
+		Overlaps bool
+	}{
+		{TCP, Reliable, true},
+		{Reliable, TCP, true},
+		{TCP4, TCP6, false},
+		{UDP4, TCP4, false},
+		{TCP, Or(), false},
+		{IPInCIDR("10.0.0.0/8"), IPInCIDR("192.168.0.0/16"), true},
+	} {
+		ok, err := Overlaps(tc.A, tc.B)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != tc.Overlaps {
+			t.Errorf("expected Overlaps(%s, %s) to be %t", tc.A, tc.B, tc.Overlaps)
+		}
+	}
+
+	if _, err := Overlaps(TCP, WebTransport); err == nil {
+		t.Error("expected an error for an unbounded repetition")
+	}
+	if _, err := Overlaps(ZeroOrMore(Base(ma.P_P2P)), TCP); err == nil {
+		t.Error("expected an error for an unbounded repetition")
+	}
+}
+
+func TestEnumerateHead(t *testing.T) {
+	// Any components can follow the head.
+	if _, err := Enumerate(Head(2, TCP4)); err == nil {
+		t.Fatal("expected an error for a head followed by any components")
+	}
+}
@@ -0,0 +1,227 @@
+package mafmt
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// exampleValues are the values used for each protocol in synthesized
+// example addresses. Protocols without a value need no entry.
+var exampleValues = map[int]string{
+	ma.P_IP4:      "127.0.0.1",
+	ma.P_IP6:      "::1",
+	ma.P_IP6ZONE:  "eth0",
+	ma.P_TCP:      "0",
+	ma.P_UDP:      "0",
+	ma.P_DCCP:     "0",
+	ma.P_SCTP:     "0",
+	ma.P_DNS:      "example.com",
+	ma.P_DNS4:     "example.com",
+	ma.P_DNS6:     "example.com",
+	ma.P_DNSADDR:  "example.com",
+	ma.P_SNI:      "example.com",
+	ma.P_P2P:      "QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	ma.P_CERTHASH: "uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+	ma.P_ONION:    "timaq4ygg2iegci7:80",
+	ma.P_ONION3:   "vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80",
+	ma.P_UNIX:     "/tmp/example.sock",
+	ma.P_MEMORY:   "0",
+}
+
+// example synthesizes the components of an address matched by p.
+func example(p Pattern) ([]ma.Multiaddr, error) {
+	switch p := p.(type) {
+	case Base:
+		proto := ma.ProtocolWithCode(int(p))
+		if proto.Code == 0 {
+			return nil, fmt.Errorf("no example for unknown protocol code %d", int(p))
+		}
+		value, ok := exampleValues[proto.Code]
+		if !ok && proto.Size != 0 {
+			return nil, fmt.Errorf("no example value for protocol %s", proto.Name)
+		}
+		c, err := ma.NewComponent(proto.Name, value)
+		if err != nil {
+			return nil, err
+		}
+		return []ma.Multiaddr{c}, nil
+	case *ipInCIDR:
+		c, err := ma.NewComponent(Base(p.code).String(), p.net.IP.String())
+		if err != nil {
+			return nil, err
+		}
+		return []ma.Multiaddr{c}, nil
+	case *portRange:
+		c, err := ma.NewComponent(Base(p.code).String(), strconv.Itoa(p.min))
+		if err != nil {
+			return nil, err
+		}
+		return []ma.Multiaddr{c}, nil
+	case *baseValue:
+		c, err := ma.NewComponent(Base(p.code).String(), p.value)
+		if err != nil {
+			return nil, err
+		}
+		return []ma.Multiaddr{c}, nil
+	case *basePredicate:
+		ex, err := example(Base(p.code))
+		if err != nil {
+			return nil, err
+		}
+		if !p.pred(ex[0].(*ma.Component).Value()) {
+			return nil, fmt.Errorf("no example value satisfies %s", p)
+		}
+		return ex, nil
+	case *dnsName:
+		c, err := ma.NewComponent("dns", p.name)
+		if err != nil {
+			return nil, err
+		}
+		return []ma.Multiaddr{c}, nil
+	case *prefix:
+		return example(p.inner)
+	case *suffix:
+		return example(p.inner)
+	case *capture:
+		return example(p.inner)
+	case *ignoring:
+		ex, err := example(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		// The inner example may use protocols p ignores, which p would
+		// skip when matching it.
+		var kept []ma.Multiaddr
+		for _, c := range ex {
+			if _, ok := p.set[c.(*ma.Component).Protocol().Code]; !ok {
+				kept = append(kept, c)
+			}
+		}
+		if !p.Matches(ma.Join(kept...)) {
+			return nil, fmt.Errorf("example for %s does not match %s without the protocols it ignores", p.inner, p)
+		}
+		return kept, nil
+	case *maxComponents:
+		ex, err := example(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		if len(ex) > p.n {
+			return nil, fmt.Errorf("example for %s has more than %d components", p.inner, p.n)
+		}
+		return ex, nil
+	case *head:
+		ex, err := example(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		if len(ex) != p.n {
+			return nil, fmt.Errorf("example for %s does not have %d components", p.inner, p.n)
+		}
+		return ex, nil
+	case *hostIsIP:
+		ex, err := example(p.inner)
+		if err != nil {
+			return nil, err
+		}
+		if len(ex) == 0 || !isIPHost(components(ex[0])[0]) {
+			return nil, fmt.Errorf("example for %s does not start with an IP host", p.inner)
+		}
+		return ex, nil
+	case *anyBase:
+		err := errors.New("no example for an empty any base")
+		codes := p.codes
+		if p.except {
+			// Use the first protocol with an example that is not excluded.
+			codes = nil
+			for _, proto := range ma.Protocols {
+				if _, ok := p.set[proto.Code]; !ok {
+					codes = append(codes, proto.Code)
+				}
+			}
+		}
+		for _, c := range codes {
+			var ex []ma.Multiaddr
+			if ex, err = example(Base(c)); err == nil {
+				return ex, nil
+			}
+		}
+		return nil, err
+	case *pattern:
+		switch p.Op {
+		case OpOr:
+			// Use the first alternative that has an example.
+			err := errors.New("no example for an empty or")
+			for _, a := range p.Args {
+				var ex []ma.Multiaddr
+				if ex, err = example(a); err == nil {
+					return ex, nil
+				}
+			}
+			return nil, err
+		case OpXOr:
+			// Use the first alternative with an example that no other
+			// alternative also matches.
+			err := errors.New("no example for an empty xor")
+			for _, a := range p.Args {
+				var ex []ma.Multiaddr
+				if ex, err = example(a); err != nil {
+					continue
+				}
+				if p.Matches(ma.Join(ex...)) {
+					return ex, nil
+				}
+				err = fmt.Errorf("example for %s also matches other alternatives", a)
+			}
+			return nil, err
+		case OpAnd, OpAnyOrder:
+			var ex []ma.Multiaddr
+			for _, a := range p.Args {
+				sub, err := example(a)
+				if err != nil {
+					return nil, err
+				}
+				ex = append(ex, sub...)
+			}
+			return ex, nil
+		case OpOptional:
+			return nil, nil
+		case OpRepeat:
+			var ex []ma.Multiaddr
+			if p.Min > 0 {
+				sub, err := example(p.Args[0])
+				if err != nil {
+					return nil, err
+				}
+				for i := 0; i < p.Min; i++ {
+					ex = append(ex, sub...)
+				}
+			}
+			return ex, nil
+		}
+	}
+	return nil, fmt.Errorf("no example for %s", p)
+}
+
+// exampleAddr implements Pattern.Example on top of example.
+func exampleAddr(p Pattern) (ma.Multiaddr, error) {
+	ex, err := example(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(ex) == 0 {
+		return nil, fmt.Errorf("%s only matches the empty multiaddr", p)
+	}
+	a := ma.Join(ex...)
+	// The examples of the arguments of a pattern are synthesized alone, so
+	// together they need not match it: an XOr may find more than one of its
+	// alternatives matching what follows, and IgnoringComponents consumes
+	// whatever follows it.
+	if !p.Matches(a) {
+		return nil, fmt.Errorf("no example for %s: the example synthesized, %s, does not match it", p, a)
+	}
+	return a, nil
+}
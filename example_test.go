@@ -0,0 +1,58 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestExample(t *testing.T) {
+	ex, err := TCP.Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ex.String(); s != "/ip4/127.0.0.1/tcp/0" {
+		t.Fatalf("unexpected example %s", s)
+	}
+
+	patterns := []Pattern{Reliable, TorReliable, UnixHTTP, P2P, Repeat(Base(ma.P_CIRCUIT), 2, 3)}
+	for _, tc := range TestVectors {
+		patterns = append(patterns, tc.Pattern)
+	}
+	for _, p := range patterns {
+		ex, err := p.Example()
+		if err != nil {
+			t.Errorf("%s: %s", p, err)
+			continue
+		}
+		if !p.Matches(ex) {
+			t.Errorf("%s does not match its example %s", p, ex)
+		}
+	}
+}
+
+func TestExampleErrors(t *testing.T) {
+	for _, p := range []Pattern{
+		Not(TCP),
+		Base(99999),
+		Base(ma.P_GARLIC64),
+		Or(),
+		And(),
+		Optional(TCP),
+		ZeroOrMore(TCP),
+		And(TCP, Not(Base(ma.P_HTTP))),
+	} {
+		if ex, err := p.Example(); err == nil {
+			t.Errorf("expected no example for %s, got %s", p, ex)
+		}
+	}
+
+	// Alternatives without an example are skipped.
+	ex, err := Or(Base(ma.P_GARLIC64), Base(ma.P_IP4)).Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := ex.String(); s != "/ip4/127.0.0.1" {
+		t.Fatalf("unexpected example %s", s)
+	}
+}
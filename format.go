@@ -0,0 +1,266 @@
+package mafmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StringCodes renders p like its String method, but with each protocol
+// written as its numeric code, such as Base(6) for tcp. It helps when
+// debugging protocol tables that disagree about names.
+func StringCodes(p Pattern) string {
+	return format(p, func(code int) string {
+		return fmt.Sprintf("Base(%d)", code)
+	})
+}
+
+// protocolName renders a protocol by name, as String does.
+func protocolName(code int) string {
+	return Base(code).String()
+}
+
+// format renders p in the String grammar, writing protocols with name.
+// Patterns from outside the package are rendered with their own String.
+func format(p Pattern, name func(code int) string) string {
+	switch p := p.(type) {
+	case Base:
+		return name(int(p))
+	case *ipInCIDR:
+		return name(p.code) + "=" + p.net.String()
+	case *portRange:
+		return fmt.Sprintf("%s=%d-%d", name(p.code), p.min, p.max)
+	case *baseValue:
+		return name(p.code) + "=" + p.value
+	case *basePredicate:
+		return name(p.code) + "=<pred>"
+	case *dnsName:
+		var names []string
+		for _, c := range dnsCodes {
+			names = append(names, name(c))
+		}
+		return "{" + strings.Join(names, "|") + "}=" + p.name
+	case *prefix:
+		return group(p.inner, name) + "/..."
+	case *suffix:
+		return ".../" + group(p.inner, name)
+	case *capture:
+		return p.name + ":" + group(p.inner, name)
+	case *anyBase:
+		var names []string
+		for _, c := range p.codes {
+			names = append(names, name(c))
+		}
+		if p.except {
+			return "[^" + strings.Join(names, "|") + "]"
+		}
+		return "{" + strings.Join(names, "|") + "}"
+	case *maxComponents:
+		return fmt.Sprintf("maxcomponents(%d, %s)", p.n, format(p.inner, name))
+	case *head:
+		return fmt.Sprintf("head(%d, %s)", p.n, format(p.inner, name))
+	case *hostIsIP:
+		return fmt.Sprintf("hostip(%s)", format(p.inner, name))
+	case *ignoring:
+		return fmt.Sprintf("ignoring(%s, %s)", p.ignoredNames(name, "|"), format(p.inner, name))
+	case *pattern:
+		return formatPattern(p, name)
+	}
+	return p.String()
+}
+
+func formatPattern(ptrn *pattern, name func(int) string) string {
+	var sub []string
+	for _, a := range ptrn.Args {
+		sub = append(sub, format(a, name))
+	}
+
+	switch ptrn.Op {
+	case OpAnd:
+		if len(sub) == 0 {
+			// The empty sequence, which would otherwise vanish.
+			return "()"
+		}
+		return strings.Join(sub, "/")
+	case OpOr:
+		return "{" + strings.Join(sub, "|") + "}"
+	case OpAnyOrder:
+		return joinGroup(sub, "&")
+	case OpXOr:
+		return joinGroup(sub, "^")
+	case OpOptional:
+		return group(ptrn.Args[0], name) + "?"
+	case OpNot:
+		return "!" + group(ptrn.Args[0], name)
+	case OpRepeat:
+		switch {
+		case ptrn.Max >= 0:
+			return fmt.Sprintf("%s{%d,%d}", group(ptrn.Args[0], name), ptrn.Min, ptrn.Max)
+		case ptrn.Min == 0:
+			return group(ptrn.Args[0], name) + "*"
+		case ptrn.Min == 1:
+			return group(ptrn.Args[0], name) + "+"
+		default:
+			return fmt.Sprintf("%s{%d,}", group(ptrn.Args[0], name), ptrn.Min)
+		}
+	default:
+		return "<invalid-op>"
+	}
+}
+
+// joinGroup renders the arguments sub of an AnyOrder or XOr separated by
+// sep. With fewer than two arguments, a trailing sep tells the group from an
+// Or, as in {tcp&}.
+func joinGroup(sub []string, sep string) string {
+	if len(sub) < 2 {
+		return "{" + strings.Join(sub, "") + sep + "}"
+	}
+	return "{" + strings.Join(sub, sep) + "}"
+}
+
+// group renders p for use as the operand of a unary operator, wrapping
+// sequences, negations and captures in parentheses so the operator applies
+// to the whole of p.
+func group(p Pattern, name func(int) string) string {
+	// An And of one pattern renders as that pattern, so it is what decides.
+	rendered := p
+	for {
+		ptrn, ok := rendered.(*pattern)
+		if !ok || ptrn.Op != OpAnd || len(ptrn.Args) != 1 {
+			break
+		}
+		rendered = ptrn.Args[0]
+	}
+	if ptrn, ok := rendered.(*pattern); ok && (ptrn.Op == OpAnd && len(ptrn.Args) > 1 || ptrn.Op == OpNot) {
+		return "(" + format(p, name) + ")"
+	}
+	if _, ok := rendered.(*capture); ok {
+		return "(" + format(p, name) + ")"
+	}
+	return format(p, name)
+}
+
+// describedPatterns are the names Describe gives to well-known patterns.
+var describedPatterns = []struct {
+	p    Pattern
+	name string
+}{
+	{IP, "IP"},
+	{DNS, "DNS"},
+	{NetworkHost, "IP or DNS"},
+	{TCP, "TCP"},
+	{UDP, "UDP"},
+	{UTP, "UTP"},
+	{QUIC, "QUIC"},
+	{QUICV1, "QUIC v1"},
+	{WebTransport, "WebTransport"},
+	{HTTP, "HTTP"},
+	{HTTPS, "HTTPS"},
+	{WS, "WebSocket"},
+	{WSS, "secure WebSocket"},
+}
+
+// Describe explains p in plain English for help text and error messages,
+// such as "p2p over (TCP, UTP, QUIC, or QUIC v1)". Well-known patterns
+// inside p are described by name, and protocols by their multiaddr names.
+func Describe(p Pattern) string {
+	for _, d := range describedPatterns {
+		if d.p.Equal(p) {
+			return d.name
+		}
+	}
+	if p, ok := p.(*anyBase); ok {
+		var names []string
+		for _, c := range p.codes {
+			names = append(names, Base(c).String())
+		}
+		if p.except {
+			return "any protocol but " + englishList(names, "or")
+		}
+		return englishList(names, "or")
+	}
+	if p, ok := p.(*maxComponents); ok {
+		return fmt.Sprintf("%s in at most %d components", describeOperand(p.inner), p.n)
+	}
+	if p, ok := p.(*head); ok {
+		return fmt.Sprintf("%s in the first %d components", describeOperand(p.inner), p.n)
+	}
+	if p, ok := p.(*hostIsIP); ok {
+		return fmt.Sprintf("%s whose host is an IP literal", describeOperand(p.inner))
+	}
+	if p, ok := p.(*ignoring); ok {
+		var names []string
+		for _, c := range p.codes {
+			names = append(names, Base(c).String())
+		}
+		return fmt.Sprintf("%s ignoring any %s", describeOperand(p.inner), englishList(names, "or"))
+	}
+	ptrn, ok := p.(*pattern)
+	if !ok {
+		return p.String()
+	}
+
+	var sub []string
+	for _, a := range ptrn.Args {
+		sub = append(sub, Describe(a))
+	}
+	switch ptrn.Op {
+	case OpOr:
+		return englishList(sub, "or")
+	case OpAnd:
+		// Describe the outermost protocol first.
+		var layers []string
+		for i := len(ptrn.Args) - 1; i >= 0; i-- {
+			layers = append(layers, describeOperand(ptrn.Args[i]))
+		}
+		return strings.Join(layers, " over ")
+	case OpOptional:
+		return "optionally " + describeOperand(ptrn.Args[0])
+	case OpNot:
+		return "anything but " + describeOperand(ptrn.Args[0])
+	case OpAnyOrder:
+		return englishList(sub, "and") + " in any order"
+	case OpXOr:
+		return "exactly one of " + englishList(sub, "or")
+	case OpRepeat:
+		arg := describeOperand(ptrn.Args[0])
+		switch {
+		case ptrn.Max < 0 && ptrn.Min == 0:
+			return "any number of " + arg
+		case ptrn.Max < 0:
+			return fmt.Sprintf("at least %d of %s", ptrn.Min, arg)
+		default:
+			return fmt.Sprintf("%d to %d of %s", ptrn.Min, ptrn.Max, arg)
+		}
+	}
+	return p.String()
+}
+
+// describeOperand describes p, in parentheses when it is a list.
+func describeOperand(p Pattern) string {
+	s := Describe(p)
+	if b, ok := p.(*anyBase); ok && (len(b.codes) > 1 || b.except) {
+		return "(" + s + ")"
+	}
+	if c, ok := p.(Composite); ok && len(c.Children()) > 1 && c.Operator() != OpNot {
+		for _, d := range describedPatterns {
+			if d.p.Equal(p) {
+				return s
+			}
+		}
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// englishList joins items as in "a, b, or c".
+func englishList(items []string, conj string) string {
+	switch len(items) {
+	case 0:
+		return "nothing"
+	case 1:
+		return items[0]
+	case 2:
+		return items[0] + " " + conj + " " + items[1]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + ", " + conj + " " + items[len(items)-1]
+}
@@ -0,0 +1,58 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestStringCodes(t *testing.T) {
+	if s := TCP.String(); s != "{{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/tcp" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := StringCodes(TCP); s != "{{Base(4)|Base(42)?/Base(41)}|{Base(53)|Base(54)|Base(55)}}/Base(6)" {
+		t.Fatalf("unexpected codes %q", s)
+	}
+
+	for p, expected := range map[Pattern]string{
+		Base(ma.P_TCP): "Base(6)",
+		Base(12345):    "Base(12345)",
+		Optional(And(Base(ma.P_TCP), Base(ma.P_TLS))): "(Base(6)/Base(448))?",
+		PortInRange(ma.P_UDP, 1, 2):                   "Base(273)=1-2",
+		Prefix(Base(ma.P_IP4)):                        "Base(4)/...",
+		AnyBase(ma.P_TCP, ma.P_UDP):                   "{Base(6)|Base(273)}",
+	} {
+		if s := StringCodes(p); s != expected {
+			t.Errorf("expected %q, got %q", expected, s)
+		}
+	}
+}
+
+func BenchmarkString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = Reliable.String()
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for p, expected := range map[Pattern]string{
+		Base(ma.P_TCP):                           "tcp",
+		TCP:                                      "TCP",
+		Reliable:                                 "TCP, UTP, QUIC, or QUIC v1",
+		P2P:                                      "p2p over (TCP, UTP, QUIC, or QUIC v1)",
+		And(IP, Base(ma.P_TCP)):                  "tcp over IP",
+		And(TCP, Optional(Noise), Base(ma.P_WS)): "ws over optionally noise over TCP",
+		WebRTCDirect2:                            "any number of certhash over webrtc-direct over UDP",
+		CertHashes:                               "any number of certhash",
+		Or(Base(ma.P_IP4)):                       "ip4",
+		XOr(TCP, UDP):                            "exactly one of TCP or UDP",
+		AnyOrder(Base(ma.P_P2P), Base(ma.P_CERTHASH)):    "p2p and certhash in any order",
+		And(AnyBase(ma.P_IP4, ma.P_IP6), Base(ma.P_TCP)): "tcp over (ip4 or ip6)",
+		And(TCP, AnyBaseExcept(ma.P_TLS)):                "(any protocol but tls) over TCP",
+	} {
+		if s := Describe(p); s != expected {
+			t.Errorf("expected %s to be described as %q, got %q", p, expected, s)
+		}
+	}
+}
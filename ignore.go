@@ -0,0 +1,121 @@
+package mafmt
+
+import (
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// IgnoringComponents matches addresses that p matches once every component
+// with one of codes is removed, wherever it appears. For example,
+// IgnoringComponents([]int{ma.P_P2P}, TCP) matches /ip4/1.2.3.4/tcp/80 with
+// or without a trailing /p2p/<id>. Like Not, it always consumes every
+// remaining component, so inside an And it only makes sense as the final
+// argument.
+func IgnoringComponents(codes []int, p Pattern) Pattern {
+	set := make(map[int]struct{}, len(codes))
+	for _, c := range codes {
+		set[c] = struct{}{}
+	}
+	return &ignoring{codes: append([]int(nil), codes...), set: set, inner: p}
+}
+
+type ignoring struct {
+	codes []int
+	set   map[int]struct{}
+	inner Pattern
+}
+
+func (p *ignoring) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *ignoring) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *ignoring) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *ignoring) Equal(other Pattern) bool {
+	o, ok := other.(*ignoring)
+	if !ok || len(o.codes) != len(p.codes) || !o.inner.Equal(p.inner) {
+		return false
+	}
+	for i, c := range p.codes {
+		if o.codes[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *ignoring) Protocols() []int {
+	return uniqueSorted(append(p.inner.Protocols(), p.codes...))
+}
+
+func (p *ignoring) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *ignoring) partialMatch(pcs []component) (bool, []component) {
+	if !p.match(pcs, nil, isEmpty) {
+		return false, nil
+	}
+	return true, pcs[len(pcs):]
+}
+
+func (p *ignoring) match(pcs []component, f *failures, next func([]component) bool) bool {
+	// Keep the index of each remaining component, to report failures at
+	// their position in the whole address.
+	var kept []component
+	var index []int
+	for i, c := range pcs {
+		if _, ok := p.set[c.Code]; !ok {
+			kept = append(kept, c)
+			index = append(index, i)
+		}
+	}
+	if f == nil {
+		return p.inner.match(kept, nil, isEmpty) && next(pcs[len(pcs):])
+	}
+
+	inner := &failures{all: kept}
+	if !p.inner.match(kept, inner, func(rem []component) bool {
+		if len(rem) != 0 {
+			inner.expect(rem, "end of address")
+			return false
+		}
+		return true
+	}) {
+		pos := len(pcs)
+		if inner.pos < len(index) {
+			pos = index[inner.pos]
+		}
+		for _, e := range inner.expected {
+			f.expect(pcs[pos:], e)
+		}
+		return false
+	}
+	n := len(f.captures)
+	f.captures = append(f.captures, inner.captures...)
+	if next(pcs[len(pcs):]) {
+		return true
+	}
+	f.captures = f.captures[:n]
+	return false
+}
+
+func (p *ignoring) String() string {
+	return format(p, protocolName)
+}
+
+// ignoredNames joins the names of the protocols ignored by p with sep.
+func (p *ignoring) ignoredNames(name func(int) string, sep string) string {
+	names := make([]string, len(p.codes))
+	for i, c := range p.codes {
+		names[i] = name(c)
+	}
+	return strings.Join(names, sep)
+}
@@ -0,0 +1,70 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestIgnoringComponents(t *testing.T) {
+	const peer = "/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+	const hash = "/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+
+	p := IgnoringComponents([]int{ma.P_P2P}, TCP)
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80" + peer, "/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4" + peer + "/tcp/80"})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/udp/80" + peer, "/ip4/1.2.3.4/tcp/80/ws" + peer, peer})
+
+	wt := IgnoringComponents([]int{ma.P_P2P, ma.P_CERTHASH}, And(QUICV1, Base(ma.P_WEBTRANSPORT)))
+	assertMatches(t, wt, []string{"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + hash + hash + peer})
+
+	// Failures are reported at their position in the whole address.
+	if err := p.MatchErr(ma.StringCast("/ip4/1.2.3.4" + peer + "/udp/80")); err == nil || err.Error() != "expected tcp after p2p but got udp at position 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.MatchErr(ma.StringCast("/ip4/1.2.3.4/tcp/80" + peer + "/ws")); err == nil || err.Error() != "expected end of address after p2p but got ws at position 3" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	values, ok := MatchCapture(IgnoringComponents([]int{ma.P_P2P}, And(IP, Capture("port", Base(ma.P_TCP)))), ma.StringCast("/ip4/1.2.3.4/tcp/80"+peer))
+	if !ok || values["port"] != "80" {
+		t.Fatalf("unexpected captures %v, %v", values, ok)
+	}
+
+	if s := wt.String(); s != "ignoring(p2p|certhash, {{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/udp/quic-v1/webtransport)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	assertRoundTrip(t, wt)
+}
+
+func TestIgnoringComponentsExample(t *testing.T) {
+	inner := IgnoringComponents([]int{ma.P_P2P}, Base(ma.P_TCP))
+	p := And(Base(ma.P_IP4), inner)
+	ex, err := p.Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Matches(ex) {
+		t.Fatalf("%s does not match its example %s", p, ex)
+	}
+	direct, err := inner.Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := ma.Join(ma.StringCast("/ip4/127.0.0.1"), direct); !ex.Equal(want) {
+		t.Fatalf("expected example %s, got %s", want, ex)
+	}
+}
+
+func TestIgnoringComponentsExampleMismatch(t *testing.T) {
+	// The inner example is made only of protocols that are ignored.
+	p := IgnoringComponents([]int{ma.P_TCP}, Base(ma.P_TCP))
+	if ex, err := p.Example(); err == nil {
+		t.Fatalf("expected no example for %s, got %s", p, ex)
+	}
+
+	// The ignoring pattern would consume the components after it.
+	and := And(IgnoringComponents([]int{ma.P_P2P}, Base(ma.P_IP4)), Base(ma.P_TCP))
+	if ex, err := and.Example(); err == nil {
+		t.Fatalf("expected no example for %s, got %s", and, ex)
+	}
+}
@@ -0,0 +1,434 @@
+package mafmt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// opNames are the names of pattern operators in their JSON form.
+var opNames = map[Op]string{
+	OpAnd:      "and",
+	OpOr:       "or",
+	OpOptional: "optional",
+	OpNot:      "not",
+	OpRepeat:   "repeat",
+	OpAnyOrder: "any-order",
+	OpXOr:      "xor",
+}
+
+// jsonPattern is the JSON form of a pattern: either a base protocol, as
+// {"base":"tcp"}, optionally with a fixed value, as
+// {"base":"tcp","value":"443"}, a hostname, as {"dns":"example.com"}, an IP
+// network, as {"cidr":"10.0.0.0/8"}, a port range, as
+// {"port":"tcp","min":1,"max":1024}, a prefix or suffix, as {"prefix":{...}}
+// or {"suffix":{...}}, a set of protocols, as {"any":["tcp","udp"]}, or of
+// protocols to exclude, as {"except":["garlic32","garlic64"]}, a
+// capture, as {"capture":"port","args":[{...}]}, a limit on the number of
+// components, as {"limit":8,"args":[{...}]}, a fixed-length head, as
+// {"head":2,"args":[{...}]}, a pattern ignoring some protocols, as
+// {"ignore":["p2p"],"args":[{...}]}, a pattern whose host must be an IP, as
+// {"hostip":{...}}, or an operator applied to its arguments, as
+// {"op":"and","args":[...]}.
+// Repetitions carry their bounds in min and max.
+type jsonPattern struct {
+	Base    string            `json:"base,omitempty"`
+	Value   string            `json:"value,omitempty"`
+	DNS     string            `json:"dns,omitempty"`
+	CIDR    string            `json:"cidr,omitempty"`
+	Port    string            `json:"port,omitempty"`
+	Prefix  json.RawMessage   `json:"prefix,omitempty"`
+	Suffix  json.RawMessage   `json:"suffix,omitempty"`
+	HostIP  json.RawMessage   `json:"hostip,omitempty"`
+	Capture string            `json:"capture,omitempty"`
+	Any     *[]string         `json:"any,omitempty"`
+	Except  *[]string         `json:"except,omitempty"`
+	Limit   *int              `json:"limit,omitempty"`
+	Head    *int              `json:"head,omitempty"`
+	Ignore  *[]string         `json:"ignore,omitempty"`
+	Op      string            `json:"op,omitempty"`
+	Args    []json.RawMessage `json:"args,omitempty"`
+	Min     *int              `json:"min,omitempty"`
+	Max     *int              `json:"max,omitempty"`
+}
+
+// ParseJSON reads a pattern from the JSON produced by marshalling it.
+func ParseJSON(data []byte) (Pattern, error) {
+	var jp jsonPattern
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return nil, err
+	}
+	return jp.pattern()
+}
+
+func (jp *jsonPattern) pattern() (Pattern, error) {
+	if jp.CIDR != "" {
+		_, ipnet, err := net.ParseCIDR(jp.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		return IPInCIDR(ipnet.String()), nil
+	}
+	if jp.Prefix != nil {
+		inner, err := ParseJSON(jp.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		return Prefix(inner), nil
+	}
+	if jp.Any != nil || jp.Except != nil {
+		names, construct := jp.Any, AnyBase
+		if jp.Except != nil {
+			names, construct = jp.Except, AnyBaseExcept
+		}
+		var codes []int
+		for _, name := range *names {
+			b, err := baseWithName(name)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, int(b))
+		}
+		return construct(codes...), nil
+	}
+	if jp.Capture != "" {
+		if len(jp.Args) != 1 {
+			return nil, fmt.Errorf("capture %q takes exactly one argument, got %d", jp.Capture, len(jp.Args))
+		}
+		inner, err := ParseJSON(jp.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Capture(jp.Capture, inner), nil
+	}
+	if jp.Limit != nil {
+		if len(jp.Args) != 1 {
+			return nil, fmt.Errorf("component limit takes exactly one argument, got %d", len(jp.Args))
+		}
+		if *jp.Limit < 0 {
+			return nil, fmt.Errorf("component limit must not be negative, got %d", *jp.Limit)
+		}
+		inner, err := ParseJSON(jp.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return MaxComponents(*jp.Limit, inner), nil
+	}
+	if jp.Head != nil {
+		if len(jp.Args) != 1 {
+			return nil, fmt.Errorf("head takes exactly one argument, got %d", len(jp.Args))
+		}
+		if *jp.Head < 0 {
+			return nil, fmt.Errorf("head length must not be negative, got %d", *jp.Head)
+		}
+		inner, err := ParseJSON(jp.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return Head(*jp.Head, inner), nil
+	}
+	if jp.Ignore != nil {
+		if len(jp.Args) != 1 {
+			return nil, fmt.Errorf("ignore takes exactly one argument, got %d", len(jp.Args))
+		}
+		var codes []int
+		for _, name := range *jp.Ignore {
+			b, err := baseWithName(name)
+			if err != nil {
+				return nil, err
+			}
+			codes = append(codes, int(b))
+		}
+		inner, err := ParseJSON(jp.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		return IgnoringComponents(codes, inner), nil
+	}
+	if jp.HostIP != nil {
+		inner, err := ParseJSON(jp.HostIP)
+		if err != nil {
+			return nil, err
+		}
+		return HostIsIP(inner), nil
+	}
+	if jp.Suffix != nil {
+		inner, err := ParseJSON(jp.Suffix)
+		if err != nil {
+			return nil, err
+		}
+		return Suffix(inner), nil
+	}
+	if jp.DNS != "" {
+		return DNSName(jp.DNS), nil
+	}
+	if jp.Port != "" {
+		b, err := baseWithName(jp.Port)
+		if err != nil {
+			return nil, err
+		}
+		if jp.Min == nil || jp.Max == nil {
+			return nil, fmt.Errorf("port range requires min and max")
+		}
+		if ma.ProtocolWithCode(int(b)).Size != 16 || *jp.Min > *jp.Max {
+			return nil, fmt.Errorf("invalid port range %s=%d-%d", b, *jp.Min, *jp.Max)
+		}
+		return PortInRange(int(b), *jp.Min, *jp.Max), nil
+	}
+	if jp.Base != "" {
+		if jp.Op != "" {
+			return nil, fmt.Errorf("pattern has both a base %q and an op %q", jp.Base, jp.Op)
+		}
+		b, err := baseWithName(jp.Base)
+		if err != nil {
+			return nil, err
+		}
+		if jp.Value == "" {
+			return b, nil
+		}
+		v, err := baseWithValue(int(b), jp.Value)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return compositeFromJSON(jp)
+}
+
+func baseWithName(name string) (Base, error) {
+	proto := ma.ProtocolWithName(name)
+	if proto.Code == 0 {
+		return 0, fmt.Errorf("unknown protocol %q", name)
+	}
+	return Base(proto.Code), nil
+}
+
+func (ptrn *pattern) MarshalJSON() ([]byte, error) {
+	name, ok := opNames[ptrn.Op]
+	if !ok {
+		return nil, fmt.Errorf("cannot marshal unrecognized pattern op %d", ptrn.Op)
+	}
+
+	jp := jsonPattern{Op: name}
+	for _, a := range ptrn.Args {
+		arg, err := json.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		jp.Args = append(jp.Args, arg)
+	}
+	if ptrn.Op == OpRepeat {
+		jp.Min, jp.Max = &ptrn.Min, &ptrn.Max
+	}
+	return json.Marshal(jp)
+}
+
+// UnmarshalJSON fills in a new, zero pattern. Patterns built by the
+// constructors, such as TCP, may be arguments of others, so they cannot be
+// changed in place: unmarshalling into one is an error, and ParseJSON
+// builds a new pattern instead.
+func (ptrn *pattern) UnmarshalJSON(data []byte) error {
+	if !ptrn.isZero() {
+		return fmt.Errorf("cannot unmarshal into the existing pattern %s, use ParseJSON", ptrn)
+	}
+	var jp jsonPattern
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	if jp.Base != "" {
+		return fmt.Errorf("cannot unmarshal base %q into a composite pattern, use ParseJSON", jp.Base)
+	}
+	p, err := compositeFromJSON(&jp)
+	if err != nil {
+		return err
+	}
+	*ptrn = *p.(*pattern)
+	return nil
+}
+
+// isZero reports whether ptrn is the zero pattern. Every pattern built by
+// the constructors differs from it, even Or(), which matches in at most one
+// way.
+func (ptrn *pattern) isZero() bool {
+	return ptrn.Args == nil && ptrn.Op == OpOr && ptrn.Min == 0 && ptrn.Max == 0 && !ptrn.single
+}
+
+// compositeFromJSON builds the pattern applying the operator of jp to its
+// arguments.
+func compositeFromJSON(jp *jsonPattern) (Pattern, error) {
+	op := Op(-1)
+	for o, name := range opNames {
+		if name == jp.Op {
+			op = o
+		}
+	}
+	if op < 0 {
+		return nil, fmt.Errorf("unrecognized pattern op %q", jp.Op)
+	}
+
+	var args []Pattern
+	for _, data := range jp.Args {
+		arg, err := ParseJSON(data)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	switch op {
+	case OpOptional, OpNot, OpRepeat:
+		if len(args) != 1 {
+			return nil, fmt.Errorf("pattern op %q takes exactly one argument, got %d", jp.Op, len(args))
+		}
+	}
+	switch op {
+	case OpAnd:
+		return And(args...), nil
+	case OpOr:
+		return Or(args...), nil
+	case OpOptional:
+		return Optional(args[0]), nil
+	case OpNot:
+		return Not(args[0]), nil
+	case OpRepeat:
+		if jp.Min == nil || jp.Max == nil {
+			return nil, fmt.Errorf("pattern op %q requires min and max", jp.Op)
+		}
+		if *jp.Min < 0 || (*jp.Max >= 0 && *jp.Min > *jp.Max) {
+			return nil, fmt.Errorf("invalid repetition bounds {%d,%d}", *jp.Min, *jp.Max)
+		}
+		return Repeat(args[0], *jp.Min, *jp.Max), nil
+	case OpAnyOrder:
+		return AnyOrder(args...), nil
+	default:
+		return XOr(args...), nil
+	}
+}
+
+func (p Base) MarshalJSON() ([]byte, error) {
+	name := ma.ProtocolWithCode(int(p)).Name
+	if name == "" {
+		return nil, fmt.Errorf("cannot marshal unknown protocol code %d", int(p))
+	}
+	return json.Marshal(jsonPattern{Base: name})
+}
+
+func (p *Base) UnmarshalJSON(data []byte) error {
+	var jp jsonPattern
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	if jp.Op != "" {
+		return fmt.Errorf("cannot unmarshal op %q into a base pattern, use ParseJSON", jp.Op)
+	}
+	if jp.Value != "" {
+		return fmt.Errorf("cannot unmarshal value %q into a base pattern, use ParseJSON", jp.Value)
+	}
+	b, err := baseWithName(jp.Base)
+	if err != nil {
+		return err
+	}
+	*p = b
+	return nil
+}
+
+func (p *ipInCIDR) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPattern{CIDR: p.net.String()})
+}
+
+func (p *portRange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPattern{Port: Base(p.code).String(), Min: &p.min, Max: &p.max})
+}
+
+func (p *baseValue) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPattern{Base: Base(p.code).String(), Value: p.value})
+}
+
+func (p *basePredicate) MarshalJSON() ([]byte, error) {
+	return nil, fmt.Errorf("cannot marshal predicate pattern %s", p)
+}
+
+func (p *dnsName) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonPattern{DNS: p.name})
+}
+
+func (p *prefix) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Prefix: inner})
+}
+
+func (p *suffix) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Suffix: inner})
+}
+
+func (p *capture) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Capture: p.name, Args: []json.RawMessage{inner}})
+}
+
+func (p *anyBase) MarshalJSON() ([]byte, error) {
+	names := []string{}
+	for _, c := range p.codes {
+		name := ma.ProtocolWithCode(c).Name
+		if name == "" {
+			return nil, fmt.Errorf("cannot marshal unknown protocol code %d", c)
+		}
+		names = append(names, name)
+	}
+	if p.except {
+		return json.Marshal(jsonPattern{Except: &names})
+	}
+	return json.Marshal(jsonPattern{Any: &names})
+}
+
+func (p *maxComponents) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Limit: &p.n, Args: []json.RawMessage{inner}})
+}
+
+func (p *head) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Head: &p.n, Args: []json.RawMessage{inner}})
+}
+
+func (p *ignoring) MarshalJSON() ([]byte, error) {
+	names := []string{}
+	for _, c := range p.codes {
+		name := ma.ProtocolWithCode(c).Name
+		if name == "" {
+			return nil, fmt.Errorf("cannot marshal unknown protocol code %d", c)
+		}
+		names = append(names, name)
+	}
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{Ignore: &names, Args: []json.RawMessage{inner}})
+}
+
+func (p *hostIsIP) MarshalJSON() ([]byte, error) {
+	inner, err := json.Marshal(p.inner)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(jsonPattern{HostIP: inner})
+}
@@ -0,0 +1,128 @@
+package mafmt
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for name, p := range map[string]Pattern{
+		"TCP":      TCP,
+		"HTTPS":    HTTPS,
+		"Reliable": Reliable,
+		"Repeat":   And(Not(Base(ma.P_P2P)), Repeat(Base(ma.P_CIRCUIT), 1, 2), Optional(ZeroOrMore(Base(ma.P_P2P)))),
+		"AnyOrder": AnyOrder(Base(ma.P_CERTHASH), Base(ma.P_P2P)),
+		"AnyBase":  And(AnyBase(ma.P_IP4, ma.P_IP6), Base(ma.P_TCP)),
+		"Except":   And(TCP, AnyBaseExcept(ma.P_GARLIC32, ma.P_GARLIC64)),
+		"Empty":    Or(AnyBase(), AnyBaseExcept()),
+	} {
+		data, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		parsed, err := ParseJSON(data)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if parsed.String() != p.String() {
+			t.Errorf("%s: expected %q, got %q", name, p, parsed)
+		}
+	}
+
+	assertMatches(t, mustParseJSON(t, HTTPS), TestVectors["HTTPS"].Good)
+	assertMismatches(t, mustParseJSON(t, HTTPS), TestVectors["HTTPS"].Bad, TestVectors["HTTP"].Good)
+}
+
+func TestJSONFormat(t *testing.T) {
+	data, err := json.Marshal(And(Base(ma.P_IP4), Base(ma.P_TCP)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := string(data); s != `{"op":"and","args":[{"base":"ip4"},{"base":"tcp"}]}` {
+		t.Fatalf("unexpected JSON %s", s)
+	}
+
+	var b Base
+	if err := json.Unmarshal([]byte(`{"base":"udp"}`), &b); err != nil {
+		t.Fatal(err)
+	}
+	if b != Base(ma.P_UDP) {
+		t.Fatalf("expected udp, got %s", b)
+	}
+
+	p := new(pattern)
+	if err := json.Unmarshal([]byte(`{"op":"or","args":[{"base":"ip4"},{"base":"ip6"}]}`), p); err != nil {
+		t.Fatal(err)
+	}
+	if ip := Or(Base(ma.P_IP4), Base(ma.P_IP6)); !p.Equal(ip) {
+		t.Fatalf("expected %s, got %s", ip, p)
+	}
+	if !p.Matches(ma.StringCast("/ip6/::1")) {
+		t.Fatalf("expected %s to match an ip6 address", p)
+	}
+}
+
+func TestJSONErrors(t *testing.T) {
+	for data, msg := range map[string]string{
+		`{"base":"foo"}`:                          `unknown protocol "foo"`,
+		`{"op":"nor","args":[]}`:                  `unrecognized pattern op "nor"`,
+		`{}`:                                      `unrecognized pattern op ""`,
+		`{"op":"and","args":[{"op":"nand"}]}`:     `unrecognized pattern op "nand"`,
+		`{"op":"not","args":[]}`:                  `takes exactly one argument`,
+		`{"op":"repeat","args":[{"base":"tcp"}]}`: `requires min and max`,
+		`{"op":"repeat","args":[{"base":"tcp"}],"min":2,"max":1}`: `invalid repetition bounds {2,1}`,
+		`{"base":"tcp","op":"and"}`:                               `both a base`,
+		`{"base":"tcp","value":"notaport"}`:                       `invalid tcp value "notaport"`,
+		`[]`:                                                      `cannot unmarshal`,
+	} {
+		p, err := ParseJSON([]byte(data))
+		if err == nil {
+			t.Errorf("expected %s to fail", data)
+			continue
+		}
+		if p != nil {
+			t.Errorf("expected no pattern for %s, got %#v", data, p)
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error for %s to contain %q, got %q", data, msg, err)
+		}
+	}
+
+	if _, err := json.Marshal(Base(99999)); err == nil {
+		t.Error("expected marshalling an unknown protocol code to fail")
+	}
+}
+
+func mustParseJSON(t *testing.T, p Pattern) Pattern {
+	t.Helper()
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestJSONUnmarshalExisting(t *testing.T) {
+	tcp := ma.StringCast("/ip4/1.2.3.4/tcp/1/http")
+	for _, p := range []Pattern{TCP, HTTP, And(), Or(), XOr()} {
+		before := p.String()
+		err := json.Unmarshal([]byte(`{"op":"and","args":[{"base":"ip4"},{"base":"udp"}]}`), p)
+		if err == nil || !strings.Contains(err.Error(), "cannot unmarshal into the existing pattern") {
+			t.Errorf("%s: expected unmarshalling into it to fail, got %v", before, err)
+		}
+		if p.String() != before {
+			t.Errorf("%s: changed to %s", before, p)
+		}
+	}
+	if !HTTP.Matches(tcp) {
+		t.Errorf("%s no longer matches %s", HTTP, tcp)
+	}
+}
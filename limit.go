@@ -0,0 +1,145 @@
+package mafmt
+
+import (
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MaxComponents matches the addresses p matches that have at most n
+// components. Matches and MatchErr reject longer addresses before decoding
+// them or trying p, so MaxComponents can guard patterns against
+// pathologically long addresses from untrusted sources. Inside a larger
+// pattern, it limits the number of components matched by p. It panics if n
+// is negative.
+func MaxComponents(n int, p Pattern) Pattern {
+	if n < 0 {
+		panic(fmt.Sprintf("mafmt: MaxComponents limit must not be negative, got %d", n))
+	}
+	return &maxComponents{n: n, inner: p}
+}
+
+type maxComponents struct {
+	n     int
+	inner Pattern
+}
+
+func (p *maxComponents) Matches(a ma.Multiaddr) bool {
+	if exceeds(a, p.n) {
+		return false
+	}
+	return matches(p, a)
+}
+
+func (p *maxComponents) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *maxComponents) MatchErr(a ma.Multiaddr) error {
+	if exceeds(a, p.n) {
+		return fmt.Errorf("address has more than %d components", p.n)
+	}
+	return matchErr(p, a)
+}
+
+func (p *maxComponents) Equal(other Pattern) bool {
+	o, ok := other.(*maxComponents)
+	return ok && o.n == p.n && o.inner.Equal(p.inner)
+}
+
+func (p *maxComponents) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *maxComponents) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *maxComponents) partialMatch(pcs []component) (bool, []component) {
+	var rem []component
+	ok := p.match(pcs, nil, func(r []component) bool {
+		rem = r
+		return true
+	})
+	return ok, rem
+}
+
+func (p *maxComponents) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return p.inner.match(pcs, f, func(rem []component) bool {
+		if len(pcs)-len(rem) > p.n {
+			f.expect(pcs[p.n:], fmt.Sprintf("at most %d components", p.n))
+			return false
+		}
+		return next(rem)
+	})
+}
+
+func (p *maxComponents) String() string {
+	return format(p, protocolName)
+}
+
+// exceeds reports whether a has more than n components, without looking
+// past the first n+1.
+func exceeds(a ma.Multiaddr, n int) bool {
+	count := 0
+	ma.ForEach(a, func(ma.Component) bool {
+		count++
+		return count <= n
+	})
+	return count > n
+}
+
+// MatchWithBudget matches p against a like Matches, but gives up once
+// matching has tried sub-patterns of p more than maxSteps times in total,
+// returning an error. Backtracking through nested Ors and repetitions can
+// take time exponential in the size of the pattern, so MatchWithBudget
+// bounds the cost of matching patterns from untrusted sources, much as
+// MaxComponents bounds the cost of untrusted addresses.
+func MatchWithBudget(p Pattern, a ma.Multiaddr, maxSteps int) (bool, error) {
+	b := &budget{left: maxSteps}
+	ok := withBudget(p, b).match(components(a), nil, isEmpty)
+	if b.left < 0 {
+		return false, fmt.Errorf("matching %s took more than %d steps", p, maxSteps)
+	}
+	return ok, nil
+}
+
+// budget counts down the steps left to a match started by MatchWithBudget.
+type budget struct {
+	left int
+}
+
+// spend takes a step from b, reporting whether any were left.
+func (b *budget) spend() bool {
+	b.left--
+	return b.left >= 0
+}
+
+// budgeted spends a step of its budget each time inner is tried, and fails
+// once the budget is used up. Only its matching methods are used.
+type budgeted struct {
+	Pattern
+	b *budget
+}
+
+func (p *budgeted) partialMatch(pcs []component) (bool, []component) {
+	if !p.b.spend() {
+		return false, nil
+	}
+	return p.Pattern.partialMatch(pcs)
+}
+
+func (p *budgeted) match(pcs []component, f *failures, next func([]component) bool) bool {
+	if !p.b.spend() {
+		return false
+	}
+	return p.Pattern.match(pcs, f, next)
+}
+
+// withBudget copies the pattern tree rooted at p, wrapping every pattern in
+// it so that trying it spends a step of b.
+func withBudget(p Pattern, b *budget) Pattern {
+	return mapTree(p, func(_, p Pattern) Pattern {
+		return &budgeted{Pattern: p, b: b}
+	})
+}
@@ -0,0 +1,116 @@
+package mafmt
+
+import (
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMaxComponents(t *testing.T) {
+	p := MaxComponents(4, And(Base(ma.P_IP4), ZeroOrMore(Base(ma.P_CIRCUIT))))
+	under := "/ip4/1.2.3.4" + strings.Repeat("/p2p-circuit", 3)
+	over := "/ip4/1.2.3.4" + strings.Repeat("/p2p-circuit", 4)
+	assertMatches(t, p, []string{"/ip4/1.2.3.4", under})
+	assertMismatches(t, p, []string{over, over + "/p2p-circuit"})
+
+	if err := p.MatchErr(ma.StringCast(under)); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := p.MatchErr(ma.StringCast(over)); err == nil || err.Error() != "address has more than 4 components" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// Inside a larger pattern, only the components of the wrapped pattern
+	// count towards the limit.
+	http := And(MaxComponents(2, TCP), Base(ma.P_HTTP))
+	assertMatches(t, http, []string{"/ip4/1.2.3.4/tcp/80/http"})
+	short := And(MaxComponents(1, TCP), Base(ma.P_HTTP))
+	assertMismatches(t, short, []string{"/ip4/1.2.3.4/tcp/80/http"})
+	if err := short.MatchErr(ma.StringCast("/ip4/1.2.3.4/tcp/80/http")); err == nil || err.Error() != "expected at most 1 components after ip4 but got tcp at position 1" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if s := p.String(); s != "maxcomponents(4, ip4/p2p-circuit*)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if !p.Equal(MaxComponents(4, And(Base(ma.P_IP4), ZeroOrMore(Base(ma.P_CIRCUIT))))) || p.Equal(MaxComponents(5, p.(*maxComponents).inner)) {
+		t.Fatal("unexpected equality")
+	}
+}
+
+func TestMaxComponentsJSON(t *testing.T) {
+	p := MaxComponents(8, Reliable)
+	assertRoundTrip(t, p)
+
+	// Untrusted JSON must not be able to build a pattern that panics.
+	for _, data := range []string{
+		`{"op":"and","args":[{"base":"ip4"},{"limit":-1,"args":[{"base":"tcp"}]}]}`,
+		`{"op":"and","args":[{"base":"ip4"},{"head":-1,"args":[{"base":"tcp"}]}]}`,
+	} {
+		if _, err := ParseJSON([]byte(data)); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+			t.Errorf("%s: unexpected error %v", data, err)
+		}
+	}
+}
+
+func TestMaxComponentsNegative(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || r != "mafmt: MaxComponents limit must not be negative, got -1" {
+			t.Fatalf("unexpected panic %v", r)
+		}
+	}()
+	MaxComponents(-1, TCP)
+}
+
+func BenchmarkMaxComponents(b *testing.B) {
+	p := MaxComponents(8, And(Base(ma.P_IP4), ZeroOrMore(Base(ma.P_CIRCUIT))))
+	a := ma.StringCast("/ip4/1.2.3.4" + strings.Repeat("/p2p-circuit", 1000))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		p.Matches(a)
+	}
+}
+
+func TestMatchWithBudget(t *testing.T) {
+	for _, tc := range []struct {
+		Addr    string
+		Matches bool
+	}{
+		{"/ip4/1.2.3.4/tcp/80", true},
+		{"/dns4/example.com/tcp/80", true},
+		{"/ip4/1.2.3.4/udp/80", false},
+	} {
+		ok, err := MatchWithBudget(TCP, ma.StringCast(tc.Addr), 100)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tc.Addr, err)
+		}
+		if ok != tc.Matches {
+			t.Fatalf("%s: expected %v, got %v", tc.Addr, tc.Matches, ok)
+		}
+	}
+
+	// Every way of splitting the circuits between the repetitions is tried
+	// before the missing udp is noticed.
+	circuit := Base(ma.P_CIRCUIT)
+	var nested Pattern = circuit
+	for i := 0; i < 4; i++ {
+		nested = OneOrMore(Or(nested, circuit))
+	}
+	p := And(Base(ma.P_IP4), nested, Base(ma.P_UDP))
+	a := ma.StringCast("/ip4/1.2.3.4" + strings.Repeat("/p2p-circuit", 12))
+	ok, err := MatchWithBudget(p, a, 10000)
+	if err == nil || !strings.Contains(err.Error(), "took more than 10000 steps") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ok {
+		t.Fatal("expected no match once the budget is exceeded")
+	}
+
+	// With a budget to spare, the result is the same as Matches.
+	short := ma.StringCast("/ip4/1.2.3.4/p2p-circuit/p2p-circuit/udp/1")
+	ok, err = MatchWithBudget(p, short, 10000)
+	if err != nil || !ok || !p.Matches(short) {
+		t.Fatalf("expected a match, got %v, %v", ok, err)
+	}
+}
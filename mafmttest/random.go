@@ -0,0 +1,56 @@
+// Package mafmttest provides helpers for testing code built on mafmt.
+package mafmttest
+
+import (
+	"math/rand"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+)
+
+// Codes are the protocols RandomPattern builds patterns from. Each has an
+// example value, so the patterns always have examples to match against.
+var Codes = []int{
+	ma.P_IP4,
+	ma.P_IP6,
+	ma.P_DNS4,
+	ma.P_TCP,
+	ma.P_UDP,
+	ma.P_QUIC_V1,
+	ma.P_TLS,
+	ma.P_WS,
+	ma.P_HTTP,
+	ma.P_P2P,
+	ma.P_CIRCUIT,
+}
+
+// RandomPattern builds a random tree of And, Or and Base patterns over Codes,
+// nested at most maxDepth deep. The same rng state always gives the same
+// pattern.
+func RandomPattern(rng *rand.Rand, maxDepth int) mafmt.Pattern {
+	if maxDepth <= 1 || rng.Intn(3) == 0 {
+		return mafmt.Base(Codes[rng.Intn(len(Codes))])
+	}
+	args := make([]mafmt.Pattern, 1+rng.Intn(3))
+	for i := range args {
+		args[i] = RandomPattern(rng, maxDepth-1)
+	}
+	if rng.Intn(2) == 0 {
+		return mafmt.And(args...)
+	}
+	return mafmt.Or(args...)
+}
+
+// RandomAddr builds a random multiaddr of up to n components from Codes,
+// for use as a negative example.
+func RandomAddr(rng *rand.Rand, n int) ma.Multiaddr {
+	var args []mafmt.Pattern
+	for i := rng.Intn(n + 1); i > 0; i-- {
+		args = append(args, mafmt.Base(Codes[rng.Intn(len(Codes))]))
+	}
+	a, err := mafmt.And(args...).Example()
+	if err != nil {
+		return ma.Join()
+	}
+	return a
+}
@@ -0,0 +1,51 @@
+package mafmttest
+
+import (
+	"math/rand"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mafmt "github.com/multiformats/go-multiaddr-fmt"
+)
+
+func TestRandomPatternReproducible(t *testing.T) {
+	for seed := int64(0); seed < 100; seed++ {
+		a := RandomPattern(rand.New(rand.NewSource(seed)), 5)
+		b := RandomPattern(rand.New(rand.NewSource(seed)), 5)
+		if !a.Equal(b) {
+			t.Fatalf("seed %d gave %s and %s", seed, a, b)
+		}
+		if d := mafmt.Depth(a); d > 5 {
+			t.Fatalf("seed %d gave %s of depth %d", seed, a, d)
+		}
+	}
+}
+
+func FuzzRandomPattern(f *testing.F) {
+	for seed := int64(0); seed < 20; seed++ {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, seed int64) {
+		rng := rand.New(rand.NewSource(seed))
+		p := RandomPattern(rng, 4)
+		simple := mafmt.Simplify(p)
+		compiled := mafmt.Compile(p)
+
+		addrs := []ma.Multiaddr{RandomAddr(rng, 4), RandomAddr(rng, 4)}
+		if ex, err := p.Example(); err == nil {
+			if !p.Matches(ex) {
+				t.Fatalf("%s does not match its example %s", p, ex)
+			}
+			addrs = append(addrs, ex)
+		}
+		for _, a := range addrs {
+			expected := p.Matches(a)
+			if simple.Matches(a) != expected {
+				t.Fatalf("simplified %s disagrees with %s on %s", simple, p, a)
+			}
+			if compiled.Match(a) != expected {
+				t.Fatalf("compiled %s disagrees on %s", p, a)
+			}
+		}
+	})
+}
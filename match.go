@@ -0,0 +1,180 @@
+package mafmt
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MatchesString parses s as a multiaddr and reports whether p matches it. It
+// returns an error if s is not a valid multiaddr.
+func MatchesString(p Pattern, s string) (bool, error) {
+	a, err := ma.NewMultiaddr(s)
+	if err != nil {
+		return false, err
+	}
+	return p.Matches(a), nil
+}
+
+// MatchesBytes decodes b as the binary form of a multiaddr and reports
+// whether p matches it. It returns an error if b is not a valid multiaddr.
+func MatchesBytes(p Pattern, b []byte) (bool, error) {
+	a, err := ma.NewMultiaddrBytes(b)
+	if err != nil {
+		return false, err
+	}
+	return p.Matches(a), nil
+}
+
+// WhichOr returns the index of the first alternative of the Or pattern p that
+// matches a. It returns false if p is not an Or or none of its alternatives
+// match.
+func WhichOr(p Pattern, a ma.Multiaddr) (int, bool) {
+	ptrn, ok := p.(*pattern)
+	if !ok || ptrn.Op != OpOr {
+		return -1, false
+	}
+	pcs := components(a)
+	for i, alt := range ptrn.Args {
+		if alt.match(pcs, nil, isEmpty) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// AsFilter returns p.Matches as a plain predicate, so that the pattern can be
+// used wherever go-multiaddr takes address filters, such as ma.FilterAddrs.
+func AsFilter(p Pattern) func(ma.Multiaddr) bool {
+	return p.Matches
+}
+
+// MatchFunc reports whether p matches a and, if it does, calls onComponent
+// with each component of a in order. A match always consumes the whole
+// address, so onComponent sees every component exactly once, and never sees
+// components tried by alternatives that failed to match.
+func MatchFunc(p Pattern, a ma.Multiaddr, onComponent func(proto ma.Protocol, value string)) bool {
+	if !p.match(components(a), nil, isEmpty) {
+		return false
+	}
+	ma.ForEach(a, func(c ma.Component) bool {
+		onComponent(c.Protocol(), c.Value())
+		return true
+	})
+	return true
+}
+
+// MatchAny reports whether any of ps matches a. It is false if ps is empty.
+func MatchAny(a ma.Multiaddr, ps ...Pattern) bool {
+	pcs := components(a)
+	for _, p := range ps {
+		if p.match(pcs, nil, isEmpty) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchAll reports whether every one of ps matches a. It is true if ps is
+// empty.
+func MatchAll(a ma.Multiaddr, ps ...Pattern) bool {
+	pcs := components(a)
+	for _, p := range ps {
+		if !p.match(pcs, nil, isEmpty) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesProtocols reports whether p matches an address made of protocols,
+// for callers that have already decomposed an address. Only the protocols
+// are known, so patterns constraining component values, such as IPInCIDR,
+// never match.
+func MatchesProtocols(p Pattern, protos []ma.Protocol) bool {
+	pcs := make([]component, len(protos))
+	for i, proto := range protos {
+		pcs[i].Code = proto.Code
+	}
+	return p.match(pcs, nil, isEmpty)
+}
+
+// MatchExact reports whether p matches the whole of a, consuming every
+// component with nothing left over. It is the same as p.Matches, and exists
+// to make that requirement explicit at the call site. Use Prefix(p) to allow
+// trailing components instead; the components it skips count as consumed.
+func MatchExact(p Pattern, a ma.Multiaddr) bool {
+	return p.match(components(a), nil, isEmpty)
+}
+
+// StripPrefix matches p against the leading components of a, as
+// p.PartialMatch does, and returns the components p did not consume. For
+// example, StripPrefix(TCP, /ip4/1.2.3.4/tcp/80/http) returns /http. When p
+// consumes the whole address, the tail is nil, as Decapsulate returns when
+// nothing is left: go-multiaddr cannot print an empty multiaddr.
+func StripPrefix(p Pattern, a ma.Multiaddr) (tail ma.Multiaddr, ok bool) {
+	ok, rem := leadingMatch(p, components(a))
+	if !ok {
+		return nil, false
+	}
+	if len(rem) == 0 {
+		return nil, true
+	}
+	cs := make([]ma.Multiaddr, len(rem))
+	for i := range rem {
+		cs[i] = rem[i].decode()
+	}
+	return ma.Join(cs...), true
+}
+
+// MatchBatch reports, for each of addrs in order, whether p matches it. The
+// pattern is compiled once and shared across the batch.
+func MatchBatch(p Pattern, addrs []ma.Multiaddr) []bool {
+	m := Compile(p)
+	results := make([]bool, len(addrs))
+	for i, a := range addrs {
+		results[i] = m.Match(a)
+	}
+	return results
+}
+
+// MatchBatchErr returns, for each of addrs in order, the error p.MatchErr
+// returns for it, which is nil for the addresses p matches.
+func MatchBatchErr(p Pattern, addrs []ma.Multiaddr) []error {
+	errs := make([]error, len(addrs))
+	for i, a := range addrs {
+		errs[i] = p.MatchErr(a)
+	}
+	return errs
+}
+
+// dialable matches p2p addresses over a dialable transport, either of the
+// peer itself or of a relay followed by the peer.
+var dialable = Or(
+	And(dialTransport, Base(ma.P_P2P)),
+	And(dialTransport, Base(ma.P_P2P), Base(ma.P_CIRCUIT), Base(ma.P_P2P)),
+)
+
+// IsDialable reports whether a can be dialed to reach a specific peer: it
+// is the peer's /p2p component over a transport such as tcp, quic-v1,
+// secure websockets or WebTransport, or the address of a relay in that form
+// followed by /p2p-circuit and the target peer's /p2p component. Addresses
+// that only make sense for listening, with an unspecified IP address such
+// as 0.0.0.0 or a port of 0, are not dialable, and neither are addresses of
+// a relay with no target.
+func IsDialable(a ma.Multiaddr) bool {
+	if !dialable.Matches(a) {
+		return false
+	}
+	listen := false
+	ma.ForEach(a, func(c ma.Component) bool {
+		switch c.Protocol().Code {
+		case ma.P_IP4, ma.P_IP6:
+			listen = net.IP(c.RawValue()).IsUnspecified()
+		case ma.P_TCP, ma.P_UDP:
+			listen = c.Value() == "0"
+		}
+		return !listen
+	})
+	return !listen
+}
@@ -0,0 +1,292 @@
+package mafmt
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestMatchesString(t *testing.T) {
+	ok, err := MatchesString(TCP, "/ip4/1.2.3.4/tcp/80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("expected a match")
+	}
+
+	ok, err = MatchesString(TCP, "/ip4/1.2.3.4/udp/80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Fatal("expected no match")
+	}
+
+	ok, err = MatchesString(TCP, "/ip4/1.2.3.4/tcp/notaport")
+	if err == nil {
+		t.Fatal("expected an error for an invalid multiaddr")
+	}
+	if ok {
+		t.Fatal("expected no match for an invalid multiaddr")
+	}
+}
+
+func TestMatchesBytes(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Addrs   []string
+		Matches bool
+	}{
+		{TCP, TestVectors["TCP"].Good, true},
+		{TCP, TestVectors["UDP"].Good, false},
+		{WebTransport, TestVectors["WebTransport"].Good, true},
+		{HTTPS, TestVectors["HTTPS"].Bad, false},
+	} {
+		for _, s := range tc.Addrs {
+			addr, err := ma.NewMultiaddr(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := MatchesBytes(tc.Pattern, addr.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.Matches {
+				t.Errorf("%s against %s: expected %t", tc.Pattern, addr, tc.Matches)
+			}
+		}
+	}
+
+	if _, err := MatchesBytes(TCP, []byte{0xff, 0xff, 0xff}); err == nil {
+		t.Fatal("expected an error for invalid bytes")
+	}
+}
+
+func TestWhichOr(t *testing.T) {
+	for s, want := range map[string]int{
+		"/ip4/1.2.3.4/tcp/80":         0,
+		"/ip4/1.2.3.4/udp/80/utp":     1,
+		"/ip4/1.2.3.4/udp/80/quic":    2,
+		"/ip4/1.2.3.4/udp/80/quic-v1": 3,
+	} {
+		i, ok := WhichOr(Reliable, ma.StringCast(s))
+		if !ok || i != want {
+			t.Errorf("expected %s to match alternative %d of Reliable, got %d %t", s, want, i, ok)
+		}
+	}
+
+	if i, ok := WhichOr(Reliable, ma.StringCast("/ip4/1.2.3.4/udp/80")); ok {
+		t.Errorf("expected no alternative to match, got %d", i)
+	}
+	if _, ok := WhichOr(Base(ma.P_TCP), ma.StringCast("/tcp/80")); ok {
+		t.Error("expected WhichOr to reject a pattern that is not an Or")
+	}
+}
+
+func TestAsFilter(t *testing.T) {
+	var addrs []ma.Multiaddr
+	for _, s := range []string{
+		"/ip4/1.2.3.4/tcp/80",
+		"/ip4/1.2.3.4/udp/80",
+		"/dns/example.com/tcp/443",
+		"/ip4/1.2.3.4/udp/443/quic-v1",
+	} {
+		addrs = append(addrs, ma.StringCast(s))
+	}
+
+	filtered := ma.FilterAddrs(addrs, AsFilter(TCP))
+	if len(filtered) != 2 {
+		t.Fatalf("expected 2 addresses, got %v", filtered)
+	}
+	for _, a := range addrs {
+		if AsFilter(TCP)(a) != TCP.Matches(a) {
+			t.Errorf("filter disagrees with TCP on %s", a)
+		}
+	}
+}
+
+func TestMatchFunc(t *testing.T) {
+	var seen []string
+	record := func(proto ma.Protocol, value string) {
+		seen = append(seen, proto.Name+"="+value)
+	}
+
+	if !MatchFunc(HTTPS, ma.StringCast("/dns4/example.com/tcp/443/https"), record) {
+		t.Fatal("expected a match")
+	}
+	if s := strings.Join(seen, " "); s != "dns4=example.com tcp=443 https=" {
+		t.Fatalf("unexpected components %q", s)
+	}
+
+	seen = nil
+	if MatchFunc(HTTPS, ma.StringCast("/dns4/example.com/tcp/443/http"), record) {
+		t.Fatal("expected no match")
+	}
+	if len(seen) != 0 {
+		t.Fatalf("expected no components from a failed match, got %v", seen)
+	}
+}
+
+func TestMatchAnyAll(t *testing.T) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/443")
+	for _, tc := range []struct {
+		Patterns []Pattern
+		Any, All bool
+	}{
+		{[]Pattern{TCP, UDP}, true, false},
+		{[]Pattern{UDP, QUICV1}, false, false},
+		{[]Pattern{TCP, Reliable, And(IPInCIDR("1.0.0.0/8"), Base(ma.P_TCP))}, true, true},
+		{nil, false, true},
+	} {
+		if ok := MatchAny(a, tc.Patterns...); ok != tc.Any {
+			t.Errorf("expected MatchAny(%v) to be %t", tc.Patterns, tc.Any)
+		}
+		if ok := MatchAll(a, tc.Patterns...); ok != tc.All {
+			t.Errorf("expected MatchAll(%v) to be %t", tc.Patterns, tc.All)
+		}
+	}
+}
+
+func TestMatchExact(t *testing.T) {
+	exact := ma.StringCast("/ip4/1.2.3.4/tcp/80")
+	trailing := ma.StringCast("/ip4/1.2.3.4/tcp/80/http")
+
+	if !MatchExact(TCP, exact) || MatchExact(TCP, trailing) {
+		t.Fatal("expected TCP to only match exactly")
+	}
+	if !MatchExact(Prefix(TCP), exact) || !MatchExact(Prefix(TCP), trailing) {
+		t.Fatal("expected Prefix(TCP) to allow trailing components")
+	}
+	if ok, _ := TCP.PartialMatch(trailing); !ok {
+		t.Fatal("expected a partial match")
+	}
+}
+
+func TestStripPrefix(t *testing.T) {
+	for _, tc := range []struct {
+		P    Pattern
+		Addr string
+		Tail string
+	}{
+		{TCP, "/ip4/1.2.3.4/tcp/80/http", "/http"},
+		{TCP, "/dns/example.com/tcp/443/tls/ws", "/tls/ws"},
+		{IP, "/ip6/::1/udp/443/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", "/udp/443/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+		{And(), "/ip4/1.2.3.4", "/ip4/1.2.3.4"},
+	} {
+		tail, ok := StripPrefix(tc.P, ma.StringCast(tc.Addr))
+		if !ok {
+			t.Fatalf("expected %s to match a prefix of %s", tc.P, tc.Addr)
+		}
+		if tail.String() != tc.Tail {
+			t.Errorf("expected the tail of %s after %s to be %q, got %q", tc.Addr, tc.P, tc.Tail, tail)
+		}
+	}
+
+	tail, ok := StripPrefix(TCP, ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if !ok || tail != nil {
+		t.Fatalf("expected a nil tail, got %v, %v", tail, ok)
+	}
+	if s := fmt.Sprint(tail); s != "<nil>" {
+		t.Fatalf("unexpected tail %q", s)
+	}
+	if _, ok := StripPrefix(UDP, ma.StringCast("/ip4/1.2.3.4/tcp/80")); ok {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestMatchBatch(t *testing.T) {
+	addrs := []ma.Multiaddr{
+		ma.StringCast("/ip4/1.2.3.4/tcp/80"),
+		ma.StringCast("/ip4/1.2.3.4/udp/80"),
+		ma.StringCast("/dns/example.com/tcp/443"),
+		ma.StringCast("/ip4/1.2.3.4/tcp/80/http"),
+	}
+	if got := MatchBatch(TCP, addrs); !slices.Equal(got, []bool{true, false, true, false}) {
+		t.Fatalf("unexpected results %v", got)
+	}
+	if got := MatchBatch(TCP, nil); len(got) != 0 {
+		t.Fatalf("expected no results, got %v", got)
+	}
+
+	errs := MatchBatchErr(TCP, addrs)
+	if len(errs) != len(addrs) {
+		t.Fatalf("expected %d errors, got %d", len(addrs), len(errs))
+	}
+	for i, err := range errs {
+		if want := TCP.MatchErr(addrs[i]); (err == nil) != (want == nil) || (err != nil && err.Error() != want.Error()) {
+			t.Errorf("expected error %v for %s, got %v", want, addrs[i], err)
+		}
+	}
+	if errs[0] != nil || errs[1] == nil || errs[2] != nil || errs[3] == nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+}
+
+func TestIsDialable(t *testing.T) {
+	const peer = "/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+	const relayID = "/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC"
+	const relay = "/ip4/1.2.3.4/tcp/4001" + relayID
+	const certhash = "uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+	for s, dialable := range map[string]bool{
+		"/ip4/1.2.3.4/tcp/4001" + peer:              true,
+		"/dns4/example.com/udp/4001/quic-v1" + peer: true,
+		relay + "/p2p-circuit" + peer:               true,
+		"/ip4/1.2.3.4/tcp/4001":                     false,
+		"/ip4/0.0.0.0/tcp/4001" + peer:              false,
+		"/ip4/1.2.3.4/tcp/0" + peer:                 false,
+		"/ip6/::/udp/4001/quic-v1" + peer:           false,
+		relay + "/p2p-circuit":                      false,
+		"/ip4/1.2.3.4/udp/4001" + peer:              false,
+
+		"/dns4/example.com/tcp/443/wss" + peer:                                  true,
+		"/ip4/1.2.3.4/tcp/443/tls/ws" + peer:                                    true,
+		"/ip4/1.2.3.4/tcp/443/tls/sni/example.com/ws" + peer:                    true,
+		"/ip4/1.2.3.4/tcp/4001/tls" + peer:                                      true,
+		"/ip4/1.2.3.4/tcp/4001/noise" + peer:                                    true,
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport" + peer:                      true,
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport/certhash/" + certhash + peer: true,
+		"/ip4/1.2.3.4/udp/443/webrtc-direct/certhash/" + certhash + peer:        true,
+		"/dns4/example.com/tcp/443/wss" + relayID + "/p2p-circuit" + peer:       true,
+		"/ip4/1.2.3.4/tcp/443/wss/p2p-circuit" + peer:                           false,
+	} {
+		if IsDialable(ma.StringCast(s)) != dialable {
+			t.Errorf("expected IsDialable(%s) to be %t", s, dialable)
+		}
+	}
+}
+
+func TestMatchesProtocols(t *testing.T) {
+	for _, tc := range TestVectors {
+		for _, s := range append(append([]string(nil), tc.Good...), tc.Bad...) {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				continue
+			}
+			if MatchesProtocols(tc.Pattern, a.Protocols()) != tc.Pattern.Matches(a) {
+				t.Errorf("MatchesProtocols and Matches disagree for %s on %s", tc.Pattern, s)
+			}
+		}
+	}
+
+	tcp := []ma.Protocol{ma.ProtocolWithCode(ma.P_IP4), ma.ProtocolWithCode(ma.P_TCP)}
+	if !MatchesProtocols(TCP, tcp) || MatchesProtocols(UDP, tcp) || MatchesProtocols(TCP, tcp[:1]) {
+		t.Fatal("unexpected protocol matches")
+	}
+	if !MatchesProtocols(And(), nil) || MatchesProtocols(TCP, nil) {
+		t.Fatal("unexpected empty matches")
+	}
+	for _, p := range []Pattern{
+		And(IPInCIDR("0.0.0.0/0"), Base(ma.P_TCP)),
+		And(Base(ma.P_IP4), PortInRange(ma.P_TCP, 0, 65535)),
+		And(Base(ma.P_IP4), BaseWithPredicate(ma.P_TCP, func(string) bool { return true })),
+		And(Base(ma.P_IP4), BaseWithValue(ma.P_TCP, "0")),
+	} {
+		if MatchesProtocols(p, tcp) {
+			t.Errorf("expected %s not to match protocols without values", p)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package mafmt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Parse reads a pattern from its String form, so that Parse(p.String())
+// yields a pattern equivalent to p. The grammar is:
+//
+//	seq      = unary *( "/" unary )
+//	unary    = "!" unary / postfix
+//	postfix  = atom *( "?" / "*" / "+" / "{" min "," [ max ] "}" )
+//	atom     = protocol / "{" [ seq *( "|" seq ) ] "}"
+//	         / "{" [ seq ] "&" "}" / "{" seq 1*( "&" seq ) "}"
+//	         / "{" [ seq ] "^" "}" / "{" seq 1*( "^" seq ) "}" / "(" [ seq ] ")"
+//
+// where a sequence of more than one element is an And, as is (), the empty
+// sequence, braces are an Or, or an AnyOrder when separated by "&" and an
+// XOr when separated by "^", and protocol is a protocol name known to
+// go-multiaddr. An AnyOrder or XOr of fewer than two patterns ends in its
+// separator, as in {tcp&} and {^}, to tell it from an Or.
+//
+// Only patterns built from Base, AnyBase and the operators above round
+// trip. Wrappers, such as Prefix, Suffix, Capture, Head, MaxComponents,
+// IgnoringComponents and HostIsIP, and patterns constraining component
+// values, such as IPInCIDR, PortInRange, BaseWithValue, BaseWithPredicate
+// and DNSName, as well as AnyBaseExcept, print forms that Parse either
+// rejects or reads as a different pattern.
+func Parse(s string) (Pattern, error) {
+	p := &parser{s: s}
+	if len(s) == 0 {
+		return And(), nil
+	}
+	ptrn, err := p.seq()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(s) {
+		return nil, p.errorf("unexpected %q", s[p.pos])
+	}
+	return ptrn, nil
+}
+
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid pattern %q at offset %d: %s", p.s, p.pos, fmt.Sprintf(format, args...))
+}
+
+// peek returns the next byte of input, or 0 at the end of the input.
+func (p *parser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) seq() (Pattern, error) {
+	var args []Pattern
+	for {
+		arg, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.peek() != '/' {
+			break
+		}
+		p.pos++
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return And(args...), nil
+}
+
+func (p *parser) unary() (Pattern, error) {
+	if p.peek() == '!' {
+		p.pos++
+		arg, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not(arg), nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (Pattern, error) {
+	ptrn, err := p.atom()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch p.peek() {
+		case '?':
+			ptrn = Optional(ptrn)
+		case '*':
+			ptrn = ZeroOrMore(ptrn)
+		case '+':
+			ptrn = OneOrMore(ptrn)
+		case '{':
+			p.pos++
+			if ptrn, err = p.bounds(ptrn); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			return ptrn, nil
+		}
+		p.pos++
+	}
+}
+
+// bounds parses the "min,max}" of a repetition of ptrn.
+func (p *parser) bounds(ptrn Pattern) (Pattern, error) {
+	min, err := p.number()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(','); err != nil {
+		return nil, err
+	}
+	max := -1
+	if p.peek() != '}' {
+		if max, err = p.number(); err != nil {
+			return nil, err
+		}
+		if min > max {
+			return nil, p.errorf("repetition minimum %d is greater than maximum %d", min, max)
+		}
+	}
+	if err := p.expect('}'); err != nil {
+		return nil, err
+	}
+	return Repeat(ptrn, min, max), nil
+}
+
+func (p *parser) number() (int, error) {
+	start := p.pos
+	for c := p.peek(); '0' <= c && c <= '9'; c = p.peek() {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, p.errorf("expected a number")
+	}
+	return strconv.Atoi(p.s[start:p.pos])
+}
+
+func (p *parser) atom() (Pattern, error) {
+	switch p.peek() {
+	case '{':
+		p.pos++
+		var alts []Pattern
+		if p.peek() == '}' {
+			p.pos++
+			return Or(), nil
+		}
+		var sep byte
+		if c := p.peek(); c == '&' || c == '^' {
+			// A group of no patterns, such as {&}.
+			sep = c
+			p.pos++
+		} else {
+			for {
+				alt, err := p.seq()
+				if err != nil {
+					return nil, err
+				}
+				alts = append(alts, alt)
+				c := p.peek()
+				if c != '|' && c != '&' && c != '^' {
+					break
+				}
+				if sep != 0 && c != sep {
+					return nil, p.errorf("cannot mix %q and %q in a group", sep, c)
+				}
+				sep = c
+				p.pos++
+				if len(alts) == 1 && sep != '|' && p.peek() == '}' {
+					// A group of one pattern, such as {tcp&}.
+					break
+				}
+			}
+		}
+		if err := p.expect('}'); err != nil {
+			return nil, err
+		}
+		switch sep {
+		case '&':
+			return AnyOrder(alts...), nil
+		case '^':
+			return XOr(alts...), nil
+		}
+		return Or(alts...), nil
+	case '(':
+		p.pos++
+		if p.peek() == ')' {
+			p.pos++
+			return And(), nil
+		}
+		ptrn, err := p.seq()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')'); err != nil {
+			return nil, err
+		}
+		return ptrn, nil
+	default:
+		return p.protocol()
+	}
+}
+
+func (p *parser) protocol() (Pattern, error) {
+	start := p.pos
+	if end := strings.IndexAny(p.s[p.pos:], "/|&^{}()?*+!,"); end < 0 {
+		p.pos = len(p.s)
+	} else {
+		p.pos += end
+	}
+	name := p.s[start:p.pos]
+	if name == "" {
+		return nil, p.errorf("expected a protocol name")
+	}
+	proto := ma.ProtocolWithName(name)
+	if proto.Code == 0 {
+		p.pos = start
+		return nil, p.errorf("unknown protocol %q", name)
+	}
+	return Base(proto.Code), nil
+}
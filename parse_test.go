@@ -0,0 +1,152 @@
+package mafmt
+
+import (
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestParseRoundTrip(t *testing.T) {
+	patterns := []Pattern{
+		Reliable,
+		Unreliable,
+		P2P,
+		TorReliable,
+		Not(QUIC),
+		Optional(Not(Base(ma.P_TLS))),
+		Not(Optional(Base(ma.P_TLS))),
+		Repeat(And(Base(ma.P_P2P), Base(ma.P_CIRCUIT)), 1, 3),
+		OneOrMore(Or(Base(ma.P_CIRCUIT), Base(ma.P_P2P))),
+		Or(),
+		And(IP, Or()),
+		AnyOrder(Base(ma.P_CERTHASH), And(Base(ma.P_P2P), Base(ma.P_CIRCUIT))),
+		XOr(TCP, UDP),
+	}
+	for _, tc := range TestVectors {
+		patterns = append(patterns, tc.Pattern)
+	}
+
+	for _, p := range patterns {
+		parsed, err := Parse(p.String())
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", p, err)
+		}
+		if parsed.String() != p.String() {
+			t.Errorf("expected %q to round trip, got %q", p, parsed)
+		}
+	}
+
+	for name, tc := range TestVectors {
+		parsed, err := Parse(tc.Pattern.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(name, func(t *testing.T) {
+			assertMatches(t, parsed, tc.Good)
+			assertMismatches(t, parsed, tc.Bad)
+		})
+	}
+}
+
+func TestParseRoundTripGroups(t *testing.T) {
+	// Groups of fewer than two patterns must keep their operator.
+	for _, p := range []Pattern{
+		XOr(TCP),
+		AnyOrder(Base(ma.P_P2P)),
+		XOr(),
+		AnyOrder(),
+		Or(Base(ma.P_P2P)),
+		And(AnyOrder(Base(ma.P_CERTHASH)), XOr(Base(ma.P_P2P))),
+	} {
+		parsed, err := Parse(p.String())
+		if err != nil {
+			t.Fatalf("failed to parse %q: %s", p, err)
+		}
+		if !parsed.Equal(p) {
+			t.Errorf("expected %q to round trip, got %q", p, parsed)
+		}
+	}
+	if s := AnyOrder(Base(ma.P_P2P)).String(); s != "{p2p&}" {
+		t.Errorf("unexpected string %q", s)
+	}
+	if s := XOr().String(); s != "{^}" {
+		t.Errorf("unexpected string %q", s)
+	}
+}
+
+func TestParseRoundTripSequences(t *testing.T) {
+	udp, ip4 := Base(ma.P_UDP), Base(ma.P_IP4)
+	for p, expected := range map[Pattern]string{
+		Repeat(And(And(udp, ip4)), 0, 1): "(udp/ip4){0,1}",
+		Not(And(And(udp, ip4))):          "!(udp/ip4)",
+		Optional(And(Not(udp))):          "(!udp)?",
+		Or(And()):                        "{()}",
+		And(udp, And()):                  "udp/()",
+		Optional(And(And(), udp)):        "(()/udp)?",
+		AnyOrder(And()):                  "{()&}",
+		And():                            "()",
+	} {
+		if s := p.String(); s != expected {
+			t.Errorf("expected %q, got %q", expected, s)
+		}
+		parsed, err := Parse(p.String())
+		if err != nil {
+			t.Errorf("failed to parse %q: %s", p, err)
+			continue
+		}
+		if parsed.String() != p.String() {
+			t.Errorf("expected %q to round trip, got %q", p, parsed)
+		}
+	}
+
+	// The one-pattern And still applies the operator to the whole sequence.
+	p, err := Parse(Repeat(And(And(udp, ip4)), 0, 1).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMatches(t, p, []string{"/udp/1/ip4/1.2.3.4"})
+	assertMismatches(t, p, []string{"/udp/1", "/ip4/1.2.3.4"})
+}
+
+func TestParse(t *testing.T) {
+	p, err := Parse("{ip4|ip6}/tcp/tls?/{ws|http}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80/ws", "/ip6/::/tcp/443/tls/http"})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/tcp/80", "/dns4/example.io/tcp/80/ws"})
+
+	p, err = Parse("ipfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.String() != "p2p" {
+		t.Fatalf("expected ipfs to resolve to p2p, got %q", p)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for s, msg := range map[string]string{
+		"ip4/foo":       "unknown protocol \"foo\"",
+		"{ip4|ip6":      "expected '}'",
+		"(ip4/tcp":      "expected ')'",
+		"ip4/":          "expected a protocol name",
+		"ip4)":          "unexpected ')'",
+		"tcp{2,1}":      "repetition minimum 2 is greater than maximum 1",
+		"tcp{,1}":       "expected a number",
+		"tcp{1}":        "expected ','",
+		"{ip4|}":        "expected a protocol name",
+		"ip4/tcp|http":  "unexpected '|'",
+		"{ip4|ip6&tcp}": "cannot mix '|' and '&' in a group",
+	} {
+		_, err := Parse(s)
+		if err == nil {
+			t.Errorf("expected %q to fail to parse", s)
+			continue
+		}
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected error for %q to contain %q, got %q", s, msg, err)
+		}
+	}
+}
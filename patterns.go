@@ -1,7 +1,11 @@
 package mafmt
 
 import (
-	"strings"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
 
 	ma "github.com/multiformats/go-multiaddr"
 )
@@ -12,156 +16,1125 @@ var DNS4 = Base(ma.P_DNS4)
 // Define a dns6 format multiaddr
 var DNS6 = Base(ma.P_DNS6)
 
-// Define a dnsaddr, dns, dns4 or dns6 format multiaddr
+// Define a dns, dns4 or dns6 format multiaddr
 var DNS = Or(
 	Base(ma.P_DNS),
 	DNS4,
 	DNS6,
 )
 
+// Define a dnsaddr format multiaddr. A dnsaddr resolves to complete
+// addresses rather than to an IP, so it carries no transport of its own.
+var DNSAddr = Base(ma.P_DNSADDR)
+
+// Define a dnsaddr of a peer, as used for bootstrap addresses
+var DNSAddrP2P = And(DNSAddr, Base(ma.P_P2P))
+
+// Define IP6Zone as the 'ip6zone' that scopes a link-local ipv6 address
+var IP6Zone = Base(ma.P_IP6ZONE)
+
+// Define IP6 as ipv6, optionally preceded by the ip6zone it is scoped to, as
+// in /ip6zone/eth0/ip6/fe80::1
+var IP6 = And(Optional(IP6Zone), Base(ma.P_IP6))
+
 // Define IP as either ipv4 or ipv6
-var IP = Or(Base(ma.P_IP4), Base(ma.P_IP6))
+var IP = Or(Base(ma.P_IP4), IP6)
 
-// Define TCP as 'tcp' on top of either ipv4 or ipv6, or dns equivalents.
-var TCP = Or(
-	And(DNS, Base(ma.P_TCP)),
-	And(IP, Base(ma.P_TCP)),
+// Define Loopback as a single ipv4 or ipv6 loopback address, such as
+// 127.0.0.1 or ::1.
+var Loopback = Or(IPInCIDR("127.0.0.0/8"), IPInCIDR("::1/128"))
+
+// Define PrivateIP as a single address in the ipv4 private ranges of RFC 1918,
+// or in the ipv6 unique local range fc00::/7.
+var PrivateIP = Or(
+	IPInCIDR("10.0.0.0/8"),
+	IPInCIDR("172.16.0.0/12"),
+	IPInCIDR("192.168.0.0/16"),
+	IPInCIDR("fc00::/7"),
 )
 
+// Define PublicIP as a single global unicast ipv4 or ipv6 address outside the
+// PrivateIP ranges. Loopback, link-local, multicast and unspecified
+// addresses are not public.
+var PublicIP = Or(BaseWithPredicate(ma.P_IP4, isPublicIP), BaseWithPredicate(ma.P_IP6, isPublicIP))
+
+func isPublicIP(value string) bool {
+	ip := net.ParseIP(value)
+	return ip != nil && ip.IsGlobalUnicast() && !ip.IsPrivate()
+}
+
+// Define a network host as either an IP address or a dns name. It always
+// consumes exactly one host component, along with the ip6zone of a scoped
+// ipv6 address.
+var NetworkHost = Or(IP, DNS)
+
+// Define TCP as 'tcp' on top of either ipv4 or ipv6, or dns equivalents.
+var TCP = And(NetworkHost, Base(ma.P_TCP))
+
 // Define UDP as 'udp' on top of either ipv4 or ipv6, or dns equivalents.
-var UDP = Or(
-	And(DNS, Base(ma.P_UDP)),
-	And(IP, Base(ma.P_UDP)),
+var UDP = And(NetworkHost, Base(ma.P_UDP))
+
+// Define TCP4 and TCP6 as 'tcp' on top of ipv4 or ipv6 respectively, with no
+// dns equivalents.
+var (
+	TCP4 = And(Base(ma.P_IP4), Base(ma.P_TCP))
+	TCP6 = And(IP6, Base(ma.P_TCP))
+)
+
+// Define UDP4 and UDP6 as 'udp' on top of ipv4 or ipv6 respectively, with no
+// dns equivalents.
+var (
+	UDP4 = And(Base(ma.P_IP4), Base(ma.P_UDP))
+	UDP6 = And(IP6, Base(ma.P_UDP))
 )
 
+// Define TLS as the 'tls' security layer
+var TLS = Base(ma.P_TLS)
+
+// Define Noise as the 'noise' security layer
+var Noise = Base(ma.P_NOISE)
+
+// Define Plaintext as the 'plaintextv2' security layer, which does not
+// encrypt or authenticate anything. It is insecure and only meant for tests.
+var Plaintext = Base(ma.P_PLAINTEXTV2)
+
+// Define SNI as the 'sni' server name that may follow tls
+var SNI = Base(ma.P_SNI)
+
+// SNIHost matches an sni component naming the server name, such as
+// "example.com".
+func SNIHost(name string) Pattern {
+	return BaseWithValue(ma.P_SNI, name)
+}
+
+// Define SecureTCP as 'tls' on top of tcp
+var SecureTCP = And(TCP, TLS)
+
 // Define UTP as 'utp' on top of udp (on top of ipv4 or ipv6).
 var UTP = And(UDP, Base(ma.P_UTP))
 
-// Define QUIC as 'quic' on top of udp (on top of ipv4 or ipv6)
-var QUIC = And(UDP, Or(Base(ma.P_QUIC_V1), Base(ma.P_QUIC)))
+// Define QUIC as 'quic' on top of udp (on top of ipv4 or ipv6). This is the
+// legacy draft-29 version of QUIC; see QUICV1 for the version libp2p uses
+// today.
+//
+// Compatibility: QUIC used to match quic-v1 addresses as well, and no longer
+// does. Use QUICAny to accept either version, or QUICV1 for quic-v1 alone.
+var QUIC = And(UDP, Base(ma.P_QUIC))
+
+// Define QUICV1 as 'quic-v1' on top of udp (on top of ipv4 or ipv6)
+var QUICV1 = And(UDP, Base(ma.P_QUIC_V1))
+
+// Define QUICAny as either version of QUIC, for checks that treat peers
+// still advertising the draft version like those using quic-v1
+var QUICAny = Or(QUIC, QUICV1)
+
+// Define CertHashes as any number of 'certhash' components, as carried by
+// transports that authenticate with self-signed certificates
+var CertHashes = ZeroOrMore(Base(ma.P_CERTHASH))
+
+// Define WebTransport as 'webtransport' on top of quic-v1, followed by any
+// number of certificate hashes
+var WebTransport = And(QUICV1, Base(ma.P_WEBTRANSPORT), CertHashes)
+
+// Define unreliable transport as bare udp. Transports layered on udp that
+// provide their own reliability, such as utp and quic, are Reliable instead.
+var Unreliable = UDP
+
+// Now define a Reliable transport as either tcp or utp or either version of
+// quic
+var Reliable = Or(TCP, UTP, QUIC, QUICV1)
+
+// Define onion as a tor hidden service address, which already includes the
+// service port
+var Onion = Base(ma.P_ONION)
 
-// Define unreliable transport as udp
-var Unreliable = Or(UDP)
+// Define onion3 as a tor v3 hidden service address, which already includes
+// the service port
+var Onion3 = Base(ma.P_ONION3)
 
-// Now define a Reliable transport as either tcp or utp or quic
-var Reliable = Or(TCP, UTP, QUIC)
+// Define http over an onion or onion3 hidden service
+var OnionHTTP = And(Or(Onion, Onion3), Base(ma.P_HTTP))
+
+// Define http over an onion3 hidden service. go-multiaddr requires the port
+// in the onion3 value, as in /onion3/<address>:80/http, so no tcp component
+// follows it
+var Onion3HTTP = And(Onion3, Base(ma.P_HTTP))
+
+// Define p2p over an onion or onion3 hidden service
+var OnionP2P = And(Or(Onion, Onion3), Base(ma.P_P2P))
+
+// Define TorReliable as any Reliable transport or a tor hidden service, which
+// is reliable on its own. It is kept apart from Reliable so that accepting
+// tor addresses is opt-in.
+var TorReliable = Or(Reliable, Onion, Onion3)
+
+// Define garlic32 as an i2p address in its base32 hash form, and garlic64 as
+// a full i2p destination in base64
+var (
+	GARLIC32 = Base(ma.P_GARLIC32)
+	GARLIC64 = Base(ma.P_GARLIC64)
+)
+
+// GARLIC32Valid and GARLIC64Valid also check the length of the decoded value:
+// exactly 32 bytes for the hash of a garlic32 address, and at least 387 bytes,
+// the keys and certificate of a destination, for garlic64. go-multiaddr itself
+// also accepts longer garlic32 values, used for encrypted leasesets, and
+// 386-byte garlic64 values.
+var (
+	GARLIC32Valid = BaseWithPredicate(ma.P_GARLIC32, garlicLength(garlic32Encoding, 32, 32))
+	GARLIC64Valid = BaseWithPredicate(ma.P_GARLIC64, garlicLength(garlic64Encoding, 387, -1))
+)
 
 // P2P can run over any reliable underlying transport protocol
 var P2P = And(Reliable, Base(ma.P_P2P))
 
+// Define a relay circuit as the relay's p2p address followed by
+// 'p2p-circuit', optionally followed by the p2p id of the target peer.
+//
+// The relay must be reachable directly: nested relays, where the relay's
+// own address is a circuit, are not matched.
+var P2PCircuit = And(P2P, Base(ma.P_CIRCUIT), Optional(Base(ma.P_P2P)))
+
 // IPFS can run over any reliable underlying transport protocol
 //
 // Deprecated: use P2P
 var IPFS = P2P
 
-// Define http over TCP or DNS or http over DNS format multiaddr
+// Define an http-path component, naming the path of an HTTP endpoint such
+// as /http-path/%2Fmy%2Fapi
+var HTTPPath = Base(ma.P_HTTP_PATH)
+
+// Define http over TCP or DNS or http over DNS format multiaddr, optionally
+// followed by an http-path
 var HTTP = Or(
-	And(TCP, Base(ma.P_HTTP)),
-	And(IP, Base(ma.P_HTTP)),
-	And(DNS, Base(ma.P_HTTP)),
+	And(TCP, Base(ma.P_HTTP), Optional(HTTPPath)),
+	And(NetworkHost, Base(ma.P_HTTP), Optional(HTTPPath)),
 )
 
-// Define https over TCP or DNS or https over DNS format multiaddr
+// Define https over TCP or DNS or https over DNS, or http over TLS over TCP,
+// optionally naming the server with SNI, format multiaddr, optionally
+// followed by an http-path
 var HTTPS = Or(
-	And(TCP, Base(ma.P_HTTPS)),
-	And(IP, Base(ma.P_HTTPS)),
-	And(DNS, Base(ma.P_HTTPS)),
+	And(TCP, Base(ma.P_HTTPS), Optional(HTTPPath)),
+	And(IP, Base(ma.P_HTTPS), Optional(HTTPPath)),
+	And(DNS, Base(ma.P_HTTPS), Optional(HTTPPath)),
+	And(SecureTCP, Optional(SNI), Base(ma.P_HTTP), Optional(HTTPPath)),
 )
 
+// Define ws over TCP or DNS or ws over DNS format multiaddr
+var WS = Or(
+	And(TCP, Base(ma.P_WS)),
+	And(IP, Base(ma.P_WS)),
+	And(DNS, Base(ma.P_WS)),
+)
+
+// Define wss over TCP or DNS or wss over DNS, or ws over TLS over TCP,
+// optionally naming the server with SNI, format multiaddr
+var WSS = Or(
+	And(TCP, Base(ma.P_WSS)),
+	And(IP, Base(ma.P_WSS)),
+	And(DNS, Base(ma.P_WSS)),
+	And(SecureTCP, Optional(SNI), Base(ma.P_WS)),
+)
+
+// Define a relay circuit over secure websockets, as browser nodes use, as the
+// relay's p2p address over WSS followed by 'p2p-circuit' and the p2p id of
+// the target peer
+var WSSCircuit = And(WSS, Base(ma.P_P2P), Base(ma.P_CIRCUIT), Base(ma.P_P2P))
+
+// Define a unix domain socket format multiaddr
+var Unix = Base(ma.P_UNIX)
+
+// Define the in-process 'memory' transport used by tests. It is not IP
+// based, so none of the IP, TCP or Reliable patterns include it.
+var Memory = Base(ma.P_MEMORY)
+
+// Define a memory transport address of a peer
+var MemoryP2P = And(Memory, Base(ma.P_P2P))
+
+// Define http over a unix domain socket, as used by the IPFS HTTP API.
+//
+// Unix paths may contain slashes, so in the textual form everything after
+// /unix is part of the path; this only matches addresses whose protocols
+// really are unix followed by http, such as ones built by encapsulation.
+var UnixHTTP = And(Unix, Base(ma.P_HTTP))
+
 // Define p2p-webrtc-direct over HTTP or p2p-webrtc-direct over HTTPS format multiaddr
+//
+// This is the legacy WebRTC transport; see WebRTCDirect2 and WebRTC.
 var WebRTCDirect = Or(
 	And(HTTP, Base(ma.P_P2P_WEBRTC_DIRECT)),
 	And(HTTPS, Base(ma.P_P2P_WEBRTC_DIRECT)))
 
+// Define webrtc-direct over UDP, followed by any number of certificate hashes
+var WebRTCDirect2 = And(UDP, Base(ma.P_WEBRTC_DIRECT), CertHashes)
+
+// dialTransport matches the transports a peer can be dialed over: any
+// Reliable transport, tcp secured with tls, optionally naming the server with
+// sni, or with noise, websockets with or without tls, WebTransport, and both
+// versions of webrtc-direct.
+var dialTransport = Or(
+	Reliable,
+	And(TCP, Or(And(TLS, Optional(SNI)), Noise)),
+	WS,
+	WSS,
+	WebTransport,
+	WebRTCDirect2,
+	WebRTCDirect,
+)
+
+// Define browser-to-browser webrtc, which is established over a relay
+// circuit: the relay's p2p address over any transport it can be dialed on,
+// then 'p2p-circuit' and 'webrtc', optionally followed by the p2p id of the
+// target peer. The relay address is required, since a bare
+// /p2p-circuit/webrtc does not say where to dial.
+var WebRTC = And(dialTransport, Base(ma.P_P2P), Base(ma.P_CIRCUIT), Base(ma.P_WEBRTC), Optional(Base(ma.P_P2P)))
+
+// Op identifies the operator of a Composite pattern.
+type Op int
+
 const (
-	or  = iota
-	and = iota
+	OpOr Op = iota
+	OpAnd
+	OpOptional
+	OpNot
+	OpRepeat
+	OpAnyOrder
+	OpXOr
 )
 
+func (o Op) String() string {
+	if name, ok := opNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Op(%d)", int(o))
+}
+
+// And matches each of ps in turn, consuming consecutive components. And()
+// with no arguments is the identity of And: it consumes no components, so it
+// matches only the empty multiaddr on its own, and the remainder of the
+// address unchanged inside a larger pattern.
 func And(ps ...Pattern) Pattern {
 	return &pattern{
-		Op:   and,
-		Args: ps,
+		Op:     OpAnd,
+		Args:   ps,
+		codes:  baseCodes(ps),
+		single: singleArgs(OpAnd, ps),
 	}
 }
 
+// Or matches any one of ps, trying them in order. Or() with no arguments is
+// the identity of Or: it never matches anything, not even the empty
+// multiaddr, so an And containing it never matches either.
 func Or(ps ...Pattern) Pattern {
 	return &pattern{
-		Op:   or,
+		Op:     OpOr,
+		Args:   ps,
+		single: singleArgs(OpOr, ps),
+	}
+}
+
+// OrDedup is like Or, but drops every alternative that is structurally
+// Equal to an earlier one, so that Ors built from many sources stay small
+// without a separate Simplify pass.
+func OrDedup(ps ...Pattern) Pattern {
+	return Or(dedup(ps)...)
+}
+
+// Merge returns an Or matching the addresses matched by either a or b. The
+// alternatives of a and b are merged into it when they are Ors themselves,
+// and duplicates are dropped as in OrDedup, so that allow-lists merged over
+// and over stay a single level deep.
+func Merge(a, b Pattern) Pattern {
+	var ps []Pattern
+	for _, p := range []Pattern{a, b} {
+		if or, ok := p.(*pattern); ok && or.Op == OpOr {
+			ps = append(ps, or.Args...)
+		} else {
+			ps = append(ps, p)
+		}
+	}
+	return OrDedup(ps...)
+}
+
+// Secure matches inner followed by a mandatory tls, noise or plaintextv2
+// security layer. Plaintext is accepted so that test setups using it match;
+// it provides no security, so callers that must refuse it should build their
+// own And(inner, Or(TLS, Noise)).
+func Secure(inner Pattern) Pattern {
+	return And(inner, Or(TLS, Noise, Plaintext))
+}
+
+// SecureOptional is like Secure, but also matches inner on its own, without
+// any security layer.
+func SecureOptional(inner Pattern) Pattern {
+	return And(inner, Optional(Or(TLS, Noise, Plaintext)))
+}
+
+// Optional matches p if it is present, and matches nothing (consuming no
+// components) otherwise.
+func Optional(p Pattern) Pattern {
+	return &pattern{
+		Op:   OpOptional,
+		Args: []Pattern{p},
+	}
+}
+
+// Not matches exactly the multiaddrs that p does not match.
+//
+// Negating a prefix is ambiguous, so Not always consumes every remaining
+// component: inside an And it only makes sense as the final argument, where
+// it checks that the rest of the address is not matched by p.
+func Not(p Pattern) Pattern {
+	return &pattern{
+		Op:   OpNot,
+		Args: []Pattern{p},
+	}
+}
+
+// XOr matches when exactly one of ps matches. Inside a larger pattern, an
+// alternative only counts as matching when the rest of the pattern also
+// matches after it, and the XOr fails whenever two or more do, even if they
+// would consume different components. Where the pattern need not reach the
+// end of the address, as for PartialMatch and inside Prefix, they are counted
+// as though the address ended where the pattern does, trying each end in
+// turn. Each alternative is tried twice, so prefer Or where exclusivity does
+// not matter.
+func XOr(ps ...Pattern) Pattern {
+	return &pattern{
+		Op:   OpXOr,
+		Args: ps,
+	}
+}
+
+// AnyOrder matches each of ps exactly once, in any order, with the
+// matches following one another so that together they consume a contiguous
+// run of components. On its own, that run is the whole address; inside an
+// And, the rest of the And matches the components after it.
+// Every ordering of ps may be tried, so matching takes O(n!) attempts for n
+// patterns in the worst case; keep n small.
+func AnyOrder(ps ...Pattern) Pattern {
+	return &pattern{
+		Op:   OpAnyOrder,
 		Args: ps,
 	}
 }
 
+// OneOrMore matches one or more consecutive repetitions of p.
+func OneOrMore(p Pattern) Pattern {
+	return &pattern{
+		Op:   OpRepeat,
+		Args: []Pattern{p},
+		Min:  1,
+		Max:  -1,
+	}
+}
+
+// ZeroOrMore matches any number of consecutive repetitions of p, including
+// none at all.
+func ZeroOrMore(p Pattern) Pattern {
+	return &pattern{
+		Op:   OpRepeat,
+		Args: []Pattern{p},
+		Max:  -1,
+	}
+}
+
+// Repeat matches between min and max consecutive repetitions of p. A max of
+// -1 leaves the number of repetitions unbounded.
+func Repeat(p Pattern, min, max int) Pattern {
+	if min < 0 {
+		panic(fmt.Sprintf("mafmt: Repeat min must not be negative, got %d", min))
+	}
+	if max >= 0 && min > max {
+		panic(fmt.Sprintf("mafmt: Repeat min %d is greater than max %d", min, max))
+	}
+	return &pattern{
+		Op:   OpRepeat,
+		Args: []Pattern{p},
+		Min:  min,
+		Max:  max,
+	}
+}
+
+// Infer returns the pattern of the protocols of a: an And of the Base of
+// each of its components, in order. It is a starting point for writing a
+// more general pattern by hand.
+func Infer(a ma.Multiaddr) Pattern {
+	var args []Pattern
+	for _, c := range components(a) {
+		args = append(args, Base(c.Code))
+	}
+	return And(args...)
+}
+
 type Pattern interface {
 	Matches(ma.Multiaddr) bool
-	partialMatch([]ma.Protocol) (bool, []ma.Protocol)
+	// PartialMatch matches the pattern against a leading portion of the
+	// multiaddr and returns the protocols that were not consumed. A true
+	// result with a non-empty remainder is a prefix match, not a full match;
+	// use Matches to require the whole multiaddr to match.
+	PartialMatch(ma.Multiaddr) (matched bool, remaining []ma.Protocol)
+	// MatchErr is like Matches, but returns a descriptive error explaining
+	// where matching failed instead of false, and nil on success. It is
+	// slower than Matches and intended for diagnostics.
+	MatchErr(ma.Multiaddr) error
+	// Equal reports whether other has the same structure as the pattern:
+	// the same operators applied to equal arguments in the same order, down
+	// to the same base protocols. The comparison is structural, not
+	// semantic, so for example Or(a, b) is not equal to Or(b, a).
+	Equal(other Pattern) bool
+	// Protocols returns the sorted, de-duplicated codes of every protocol
+	// the pattern refers to.
+	Protocols() []int
+	// Example synthesizes a multiaddr that the pattern matches, using
+	// placeholder values such as /ip4/127.0.0.1 and /tcp/0. Alternatives
+	// fall back to the first one with an example, and optional parts are
+	// left out. It returns an error when no example can be built, such as
+	// for negations or protocols without a sensible placeholder value.
+	Example() (ma.Multiaddr, error)
+	partialMatch([]component) (bool, []component)
+	// match calls next with the remainder left by each way the pattern can
+	// match a prefix of pcs, most preferred first, until next returns true.
+	// It reports whether next ever returned true. Failed attempts are
+	// recorded in f, unless it is nil.
+	match(pcs []component, f *failures, next func([]component) bool) bool
 	String() string
 }
 
+// Composite is a pattern built by applying an operator to other patterns,
+// such as those returned by And and Or. Patterns that are not Composite,
+// such as Base, are leaves.
+//
+// Composite only allows patterns to be inspected; they are built with And,
+// Or and the other constructors in this package.
+type Composite interface {
+	Pattern
+	// Operator returns the operator applied to the children.
+	Operator() Op
+	// Children returns the patterns the operator is applied to. The slice
+	// is a copy, so modifying it does not affect the pattern.
+	Children() []Pattern
+}
+
 type pattern struct {
 	Args []Pattern
-	Op   int
+	Op   Op
+	Min  int
+	Max  int
+
+	// codes are the protocols an And made only of Bases, directly or in
+	// nested Ands, matches in order, or nil for any other pattern.
+	codes []int
+	// single is set for an And or Or that matches in at most one way, so
+	// that matching it needs no backtracking; see singleArgs.
+	single bool
+}
+
+func (ptrn *pattern) Operator() Op {
+	return ptrn.Op
+}
+
+func (ptrn *pattern) Children() []Pattern {
+	return append([]Pattern(nil), ptrn.Args...)
 }
 
 func (ptrn *pattern) Matches(a ma.Multiaddr) bool {
-	ok, rem := ptrn.partialMatch(a.Protocols())
-	return ok && len(rem) == 0
+	return matches(ptrn, a)
+}
+
+func (ptrn *pattern) MatchErr(a ma.Multiaddr) error {
+	return matchErr(ptrn, a)
+}
+
+func (ptrn *pattern) Equal(other Pattern) bool {
+	o, ok := other.(*pattern)
+	if !ok || o.Op != ptrn.Op || o.Min != ptrn.Min || o.Max != ptrn.Max || len(o.Args) != len(ptrn.Args) {
+		return false
+	}
+	for i, a := range ptrn.Args {
+		if !a.Equal(o.Args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ptrn *pattern) Protocols() []int {
+	var codes []int
+	for _, a := range ptrn.Args {
+		codes = append(codes, a.Protocols()...)
+	}
+	return uniqueSorted(codes)
+}
+
+// uniqueSorted sorts codes and removes duplicates in place.
+func uniqueSorted(codes []int) []int {
+	sort.Ints(codes)
+	out := codes[:0]
+	for i, c := range codes {
+		if i == 0 || c != codes[i-1] {
+			out = append(out, c)
+		}
+	}
+	return out
 }
 
-func (ptrn *pattern) partialMatch(pcs []ma.Protocol) (bool, []ma.Protocol) {
+func (ptrn *pattern) Example() (ma.Multiaddr, error) {
+	return exampleAddr(ptrn)
+}
+
+func (ptrn *pattern) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(ptrn, a)
+}
+
+func (ptrn *pattern) partialMatch(pcs []component) (bool, []component) {
+	if ptrn.single {
+		return ptrn.matchSingle(pcs)
+	}
+	var rem []component
+	ok := ptrn.match(pcs, nil, func(r []component) bool {
+		rem = r
+		return true
+	})
+	return ok, rem
+}
+
+func (ptrn *pattern) match(pcs []component, f *failures, next func([]component) bool) bool {
+	if ptrn.single && f == nil {
+		ok, rem := ptrn.matchSingle(pcs)
+		return ok && next(rem)
+	}
 	switch ptrn.Op {
-	case or:
+	case OpOr:
+		for _, a := range ptrn.Args {
+			if a.match(pcs, f, next) {
+				return true
+			}
+		}
+		return false
+	case OpAnd:
+		if ptrn.codes != nil {
+			return matchCodes(ptrn.codes, pcs, f, next)
+		}
+		return matchSeq(ptrn.Args, pcs, f, next)
+	case OpOptional:
+		return ptrn.Args[0].match(pcs, f, next) || next(pcs)
+	case OpNot:
+		if ptrn.Args[0].match(pcs, nil, isEmpty) {
+			f.expect(pcs, ptrn.String())
+			return false
+		}
+		return next(pcs[len(pcs):])
+	case OpRepeat:
+		return ptrn.matchRepeat(pcs, 0, f, next)
+	case OpAnyOrder:
+		return matchAnyOrder(ptrn.Args, pcs, f, next)
+	case OpXOr:
+		return ptrn.matchXOr(pcs, f, next)
+	default:
+		// An unrecognized op never matches.
+		f.expect(pcs, ptrn.String())
+		return false
+	}
+}
+
+// baseCodes flattens the arguments of an And into the protocols they match,
+// if they are all Bases or Ands of Bases, and returns nil otherwise.
+func baseCodes(ps []Pattern) []int {
+	codes := make([]int, 0, len(ps))
+	for _, p := range ps {
+		switch p := p.(type) {
+		case Base:
+			codes = append(codes, int(p))
+		case *pattern:
+			if p.Op != OpAnd || p.codes == nil {
+				return nil
+			}
+			codes = append(codes, p.codes...)
+		default:
+			return nil
+		}
+	}
+	return codes
+}
+
+// maxSingleSeqs bounds the number of protocol sequences singleArgs
+// enumerates to prove the alternatives of a pattern exclusive.
+const maxSingleSeqs = 256
+
+// singleArgs reports whether op applied to ps matches in at most one way,
+// returning the only remainder it can leave, so that it can be matched
+// without continuations, the same way for every caller. Bases and other
+// single-component leaves match in at most one way, and so does an And of
+// patterns that do. An Or does if its alternatives do and are exclusive: no
+// protocol sequence one accepts starts with a sequence another accepts, so
+// at most one of them can match any address. An Optional inside an And is
+// treated as the choice between the rest of the And with and without it.
+func singleArgs(op Op, ps []Pattern) bool {
+	switch op {
+	case OpAnd:
+		for i, p := range ps {
+			if opt, ok := p.(*pattern); ok && opt.Op == OpOptional {
+				rest := ps[i+1:]
+				with := append([]Pattern{opt.Args[0]}, rest...)
+				if !singleWay(opt.Args[0]) || !exclusive(&pattern{Op: OpAnd, Args: with}, &pattern{Op: OpAnd, Args: rest}) {
+					return false
+				}
+				continue
+			}
+			if !singleWay(p) {
+				return false
+			}
+		}
+		return true
+	case OpOr:
+		for _, p := range ps {
+			if !singleWay(p) {
+				return false
+			}
+		}
+		return exclusive(ps...)
+	}
+	return false
+}
+
+// singleWay reports whether p matches in at most one way.
+func singleWay(p Pattern) bool {
+	switch p := p.(type) {
+	case Base, *anyBase, *ipInCIDR, *portRange, *baseValue, *basePredicate, *dnsName:
+		return true
+	case *capture:
+		return singleWay(p.inner)
+	case *pattern:
+		return p.single
+	}
+	return false
+}
+
+// exclusive reports whether no protocol sequence accepted by one of ps is a
+// prefix of one accepted by another, giving up on patterns with too many.
+func exclusive(ps ...Pattern) bool {
+	seqs := make([][][]int, len(ps))
+	total := 0
+	for i, p := range ps {
+		if total += countSeqs(p); total > maxSingleSeqs {
+			return false
+		}
+		var err error
+		if seqs[i], err = Enumerate(p); err != nil {
+			return false
+		}
+	}
+	for i := range seqs {
+		for j := i + 1; j < len(seqs); j++ {
+			for _, a := range seqs[i] {
+				for _, b := range seqs[j] {
+					if n := min(len(a), len(b)); slices.Equal(a[:n], b[:n]) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// countSeqs bounds the number of protocol sequences Enumerate lists for p,
+// saturating above maxSingleSeqs, so that large patterns are rejected
+// before they are enumerated.
+func countSeqs(p Pattern) int {
+	switch p := p.(type) {
+	case Base, *ipInCIDR, *portRange, *baseValue, *basePredicate:
+		return 1
+	case *dnsName:
+		return len(dnsCodes)
+	case *anyBase:
+		return len(p.codes)
+	case *capture:
+		return countSeqs(p.inner)
+	case *pattern:
+		n := 0
+		switch p.Op {
+		case OpAnd:
+			n = 1
+			for _, a := range p.Args {
+				n = min(n*countSeqs(a), maxSingleSeqs+1)
+			}
+		case OpOr:
+			for _, a := range p.Args {
+				n = min(n+countSeqs(a), maxSingleSeqs+1)
+			}
+		case OpOptional:
+			n = min(1+countSeqs(p.Args[0]), maxSingleSeqs+1)
+		default:
+			n = maxSingleSeqs + 1
+		}
+		return n
+	}
+	return maxSingleSeqs + 1
+}
+
+// matchSingle is partialMatch for patterns that match in at most one way.
+// It calls the partialMatch of each argument in turn, much as matchCodes
+// checks codes, rather than building a continuation for each.
+func (ptrn *pattern) matchSingle(pcs []component) (bool, []component) {
+	if ptrn.Op == OpOr {
 		for _, a := range ptrn.Args {
-			ok, rem := a.partialMatch(pcs)
-			if ok {
+			if ok, rem := a.partialMatch(pcs); ok {
 				return true, rem
 			}
 		}
 		return false, nil
-	case and:
-		if len(pcs) < len(ptrn.Args) {
+	}
+	if ptrn.codes != nil {
+		if len(pcs) < len(ptrn.codes) {
 			return false, nil
 		}
-
-		for i := 0; i < len(ptrn.Args); i++ {
-			ok, rem := ptrn.Args[i].partialMatch(pcs)
-			if !ok {
+		for i, code := range ptrn.codes {
+			if pcs[i].Code != code {
 				return false, nil
 			}
+		}
+		return true, pcs[len(ptrn.codes):]
+	}
+	return matchSingleSeq(ptrn.Args, pcs)
+}
 
-			pcs = rem
+// matchSingleSeq matches each of ps in turn. An Optional is matched with
+// the rest of ps, and failing that, the rest of ps is matched without it.
+func matchSingleSeq(ps []Pattern, pcs []component) (bool, []component) {
+	for i, p := range ps {
+		if opt, ok := p.(*pattern); ok && opt.Op == OpOptional {
+			if ok, rem := opt.Args[0].partialMatch(pcs); ok {
+				if ok, rem := matchSingleSeq(ps[i+1:], rem); ok {
+					return true, rem
+				}
+			}
+			continue
 		}
+		ok, rem := p.partialMatch(pcs)
+		if !ok {
+			return false, nil
+		}
+		pcs = rem
+	}
+	return true, pcs
+}
 
-		return true, pcs
-	default:
-		panic("unrecognized pattern operand")
+// matchCodes matches one component of each of codes in turn. Each consumes
+// exactly one component, so a short address is rejected up front, and there
+// is nothing to backtrack into. Diagnostics still need the full match to
+// find where it failed.
+func matchCodes(codes []int, pcs []component, f *failures, next func([]component) bool) bool {
+	if f == nil && len(pcs) < len(codes) {
+		return false
 	}
+	for i, code := range codes {
+		if i == len(pcs) || pcs[i].Code != code {
+			f.expect(pcs[i:], Base(code).String())
+			return false
+		}
+	}
+	return next(pcs[len(codes):])
 }
 
-func (ptrn *pattern) String() string {
-	var sub []string
-	for _, a := range ptrn.Args {
-		sub = append(sub, a.String())
+// matchSeq matches each of ps in turn, backtracking into earlier patterns
+// when a later one fails.
+func matchSeq(ps []Pattern, pcs []component, f *failures, next func([]component) bool) bool {
+	if len(ps) == 0 {
+		return next(pcs)
+	}
+	if b, ok := ps[0].(Base); ok {
+		// Bases match at most one way, so there is nothing to backtrack
+		// into and no need to allocate a continuation.
+		if len(pcs) == 0 || pcs[0].Code != int(b) {
+			f.expect(pcs, b.String())
+			return false
+		}
+		return matchSeq(ps[1:], pcs[1:], f, next)
+	}
+	if f == nil && singleWay(ps[0]) {
+		// Neither do patterns that match in at most one way, and without
+		// diagnostics to record their partialMatch is all there is to it.
+		ok, rem := ps[0].partialMatch(pcs)
+		return ok && matchSeq(ps[1:], rem, f, next)
+	}
+	if opt, ok := ps[0].(*pattern); ok && f == nil && opt.Op == OpOptional && singleWay(opt.Args[0]) {
+		// Likewise an Optional of one is either taken once or skipped.
+		if ok, rem := opt.Args[0].partialMatch(pcs); ok && matchSeq(ps[1:], rem, f, next) {
+			return true
+		}
+		return matchSeq(ps[1:], pcs, f, next)
+	}
+	if len(ps) == 1 {
+		return ps[0].match(pcs, f, next)
 	}
+	return ps[0].match(pcs, f, func(rem []component) bool {
+		return matchSeq(ps[1:], rem, f, next)
+	})
+}
 
-	switch ptrn.Op {
-	case and:
-		return strings.Join(sub, "/")
-	case or:
-		return "{" + strings.Join(sub, "|") + "}"
-	default:
-		panic("unrecognized pattern op!")
+// matchXOr matches the only alternative that can be followed by next, once
+// it has been found, so that next sees only that alternative's remainders.
+func (ptrn *pattern) matchXOr(pcs []component, f *failures, next func([]component) bool) bool {
+	// The speculative matches run next, which may record captures in f
+	// although this match is not final, so those are forgotten after each.
+	n := 0
+	if f != nil {
+		n = len(f.captures)
+	}
+	only := -1
+	for i, a := range ptrn.Args {
+		ok := a.match(pcs, nil, next)
+		if f != nil {
+			f.captures = f.captures[:n]
+		}
+		if !ok {
+			continue
+		}
+		if only >= 0 {
+			f.expect(pcs, ptrn.String())
+			return false
+		}
+		only = i
+	}
+	if only < 0 {
+		// Match again to record why each alternative failed.
+		for _, a := range ptrn.Args {
+			a.match(pcs, f, next)
+		}
+		return false
 	}
+	return ptrn.Args[only].match(pcs, f, next)
+}
+
+// matchEnds matches p against the leading components of pcs, then calls
+// next with the rest. Each end of the match is tried in turn, longest match
+// first, with p matched up to that end alone, so that the alternatives of
+// an XOr in p are counted the same way as when p has to match the whole
+// address.
+func matchEnds(p Pattern, pcs []component, f *failures, next func([]component) bool) bool {
+	for r := 0; r <= len(pcs); r++ {
+		n := 0
+		if f != nil {
+			n = len(f.captures)
+		}
+		if p.match(pcs, f, func(rem []component) bool { return len(rem) == r }) && next(pcs[len(pcs)-r:]) {
+			return true
+		}
+		if f != nil {
+			f.captures = f.captures[:n]
+		}
+	}
+	return false
+}
+
+// hasXOr reports whether the pattern tree rooted at p contains an XOr.
+func hasXOr(p Pattern) bool {
+	found := false
+	Walk(p, func(p Pattern) bool {
+		if ptrn, ok := p.(*pattern); ok && ptrn.Op == OpXOr {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// matchAnyOrder matches each of ps in turn in every possible order, trying
+// the earliest unmatched pattern first.
+func matchAnyOrder(ps []Pattern, pcs []component, f *failures, next func([]component) bool) bool {
+	if len(ps) == 0 {
+		return next(pcs)
+	}
+	for i, p := range ps {
+		rest := append(append([]Pattern(nil), ps[:i]...), ps[i+1:]...)
+		if p.match(pcs, f, func(rem []component) bool {
+			return matchAnyOrder(rest, rem, f, next)
+		}) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRepeat greedily matches further repetitions after n have been
+// matched, backing off one repetition at a time when the rest of the
+// pattern fails.
+func (ptrn *pattern) matchRepeat(pcs []component, n int, f *failures, next func([]component) bool) bool {
+	if ptrn.Max < 0 || n < ptrn.Max {
+		more := ptrn.Args[0].match(pcs, f, func(rem []component) bool {
+			if len(rem) == len(pcs) {
+				// An empty repetition can be repeated to satisfy any
+				// minimum, but never makes progress.
+				return next(rem)
+			}
+			return ptrn.matchRepeat(rem, n+1, f, next)
+		})
+		if more {
+			return true
+		}
+	}
+	return n >= ptrn.Min && next(pcs)
+}
+
+func isEmpty(pcs []component) bool {
+	return len(pcs) == 0
+}
+
+// matchOne implements match for patterns whose partialMatch has at most one
+// way of matching.
+func matchOne(p Pattern, pcs []component, f *failures, next func([]component) bool) bool {
+	ok, rem := p.partialMatch(pcs)
+	if !ok {
+		f.expect(pcs, p.String())
+		return false
+	}
+	return next(rem)
+}
+
+// matches implements Pattern.Matches on top of the pattern's matcher.
+func matches(p Pattern, a ma.Multiaddr) bool {
+	// Nothing keeps the components once a plain match returns, so their
+	// slice is reused.
+	buf := componentBufs.Get().(*[]component)
+	pcs := appendComponents((*buf)[:0], a)
+	ok := p.match(pcs, nil, isEmpty)
+	// Drop the references to a before pooling the slice.
+	clear(pcs)
+	*buf = pcs
+	componentBufs.Put(buf)
+	return ok
+}
+
+var componentBufs = sync.Pool{New: func() any { return new([]component) }}
+
+// partialMatch implements Pattern.PartialMatch on top of the pattern's
+// matcher.
+func partialMatch(p Pattern, a ma.Multiaddr) (bool, []ma.Protocol) {
+	ok, rem := leadingMatch(p, components(a))
+	if !ok {
+		return false, nil
+	}
+	return true, protocols(rem)
+}
+
+// leadingMatch is p.partialMatch, except that an XOr in p counts its
+// alternatives as matchEnds does.
+func leadingMatch(p Pattern, pcs []component) (bool, []component) {
+	if !hasXOr(p) {
+		return p.partialMatch(pcs)
+	}
+	var rem []component
+	ok := matchEnds(p, pcs, nil, func(r []component) bool {
+		rem = r
+		return true
+	})
+	return ok, rem
+}
+
+// component is a single component of an address being matched. Only its
+// protocol code is read up front; its value is only decoded on demand, by
+// patterns that constrain it.
+type component struct {
+	Code int
+	// raw is the encoded component, or nil for a bare protocol, as in
+	// MatchesProtocols.
+	raw []byte
+}
+
+func components(a ma.Multiaddr) []component {
+	return appendComponents(make([]component, 0, 8), a)
+}
+
+// appendComponents appends the components of a to cs.
+func appendComponents(cs []component, a ma.Multiaddr) []component {
+	ma.ForEach(a, func(c ma.Component) bool {
+		cs = append(cs, component{Code: c.Protocol().Code, raw: c.Bytes()})
+		return true
+	})
+	return cs
+}
+
+// hasValue reports whether c came from an address, rather than from a bare
+// protocol as in MatchesProtocols, so that its value can be checked.
+func (c component) hasValue() bool {
+	return c.raw != nil
+}
+
+// decode returns c as a multiaddr component. It must have a value.
+func (c component) decode() *ma.Component {
+	var mc ma.Component
+	if err := mc.UnmarshalBinary(c.raw); err != nil {
+		// The bytes were read from a valid multiaddr.
+		panic(err)
+	}
+	return &mc
+}
+
+// name returns the name of the protocol of c.
+func (c component) name() string {
+	return Base(c.Code).String()
+}
+
+func protocols(cs []component) []ma.Protocol {
+	var pcs []ma.Protocol
+	for _, c := range cs {
+		pcs = append(pcs, ma.ProtocolWithCode(c.Code))
+	}
+	return pcs
+}
+
+func (ptrn *pattern) String() string {
+	return format(ptrn, protocolName)
 }
 
 type Base int
 
+// BaseNamed returns the Base for the protocol called name, such as "tcp", for
+// building patterns from configuration without knowing protocol codes. It
+// returns an error if go-multiaddr knows no protocol by that name.
+func BaseNamed(name string) (Pattern, error) {
+	b, err := baseWithName(name)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
 func (p Base) Matches(a ma.Multiaddr) bool {
-	pcs := a.Protocols()
-	return pcs[0].Code == int(p) && len(pcs) == 1
+	n, ok := 0, false
+	ma.ForEach(a, func(c ma.Component) bool {
+		n++
+		ok = c.Protocol().Code == int(p)
+		return n == 1
+	})
+	return ok && n == 1
 }
 
-func (p Base) partialMatch(pcs []ma.Protocol) (bool, []ma.Protocol) {
+func (p Base) Equal(other Pattern) bool {
+	o, ok := other.(Base)
+	return ok && o == p
+}
+
+func (p Base) Protocols() []int {
+	return []int{int(p)}
+}
+
+func (p Base) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p Base) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p Base) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p Base) partialMatch(pcs []component) (bool, []component) {
 	if len(pcs) == 0 {
 		return false, nil
 	}
@@ -171,6 +1144,115 @@ func (p Base) partialMatch(pcs []ma.Protocol) (bool, []ma.Protocol) {
 	return false, nil
 }
 
+func (p Base) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+// protocolNames caches the names of protocols by code for Base.String.
+// Protocols cannot be removed from go-multiaddr once added, so a cached name
+// never goes stale; unknown codes are not cached, as they may be added later.
+var protocolNames sync.Map
+
 func (p Base) String() string {
-	return ma.ProtocolWithCode(int(p)).Name
+	if name, ok := protocolNames.Load(int(p)); ok {
+		return name.(string)
+	}
+	if name := ma.ProtocolWithCode(int(p)).Name; name != "" {
+		protocolNames.Store(int(p), name)
+		return name
+	}
+	return fmt.Sprintf("<unknown:%d>", int(p))
+}
+
+// AnyBase matches a single component whose protocol is any of codes. It
+// matches the same addresses as an Or of the Base of each code, but looks
+// the protocol up in a set instead of trying each alternative in turn.
+func AnyBase(codes ...int) Pattern {
+	set := make(map[int]struct{}, len(codes))
+	for _, c := range codes {
+		set[c] = struct{}{}
+	}
+	return &anyBase{codes: append([]int(nil), codes...), set: set}
+}
+
+// AnyBaseExcept matches a single component whose protocol is not any of
+// codes. Unlike Not, it always consumes exactly one component, so it can be
+// used anywhere inside an And: And(TCP, AnyBaseExcept(ma.P_GARLIC32,
+// ma.P_GARLIC64)) matches a tcp address followed by one more layer of any
+// protocol but garlic.
+func AnyBaseExcept(codes ...int) Pattern {
+	p := AnyBase(codes...).(*anyBase)
+	p.except = true
+	return p
+}
+
+// anyBase matches a protocol in codes, or with except set, a protocol not in
+// codes.
+type anyBase struct {
+	codes  []int
+	set    map[int]struct{}
+	except bool
+}
+
+func (p *anyBase) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *anyBase) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *anyBase) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *anyBase) Equal(other Pattern) bool {
+	o, ok := other.(*anyBase)
+	if !ok || o.except != p.except || len(o.codes) != len(p.codes) {
+		return false
+	}
+	for i, c := range p.codes {
+		if o.codes[i] != c {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *anyBase) Protocols() []int {
+	return uniqueSorted(append([]int(nil), p.codes...))
+}
+
+func (p *anyBase) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *anyBase) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 {
+		return false, nil
+	}
+	if _, ok := p.set[pcs[0].Code]; ok == p.except {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *anyBase) match(pcs []component, f *failures, next func([]component) bool) bool {
+	ok, rem := p.partialMatch(pcs)
+	if !ok && p.except {
+		f.expect(pcs, p.String())
+		return false
+	}
+	if !ok {
+		// Report each protocol, as an Or of Bases would.
+		for _, c := range p.codes {
+			f.expect(pcs, Base(c).String())
+		}
+		return false
+	}
+	return next(rem)
+}
+
+func (p *anyBase) String() string {
+	return format(p, protocolName)
 }
@@ -1,6 +1,8 @@
 package mafmt
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
 	ma "github.com/multiformats/go-multiaddr"
@@ -35,9 +37,29 @@ var TestVectors = map[string]*testVector{
 	},
 	"QUIC": {
 		Pattern: QUIC,
-		Good:    []string{"/ip4/1.2.3.4/udp/1234/quic", "/ip6/::/udp/1234/quic", "/ip4/1.2.3.4/udp/1234/quic-v1", "/ip6/::/udp/1234/quic-v1"},
+		Good:    []string{"/ip4/1.2.3.4/udp/1234/quic", "/ip6/::/udp/1234/quic"},
 		Bad:     []string{"/ip4/0.0.0.0/tcp/12345/quic", "/ip6/1.2.3.4/ip4/0.0.0.0/udp/1234/quic", "/quic"},
 	},
+	"QUICV1": {
+		Pattern: QUICV1,
+		Good:    []string{"/ip4/1.2.3.4/udp/1234/quic-v1", "/ip6/::/udp/1234/quic-v1", "/dns4/example.io/udp/1234/quic-v1"},
+		Bad:     []string{"/ip4/0.0.0.0/tcp/12345/quic-v1", "/ip4/1.2.3.4/udp/1234/quic", "/quic-v1"},
+	},
+	"WebTransport": {
+		Pattern: WebTransport,
+		Good: []string{
+			"/ip4/1.2.3.4/udp/443/quic-v1/webtransport",
+			"/ip6/::/udp/443/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+			"/ip4/1.2.3.4/udp/443/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g/certhash/uEiAkH5a4DPGKUuOBjYw0CgwjvcJCJMD2K_1aluKR_tpevQ",
+			"/dns4/example.io/udp/443/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+		},
+		Bad: []string{
+			"/ip4/1.2.3.4/udp/443/quic-v1",
+			"/ip4/1.2.3.4/tcp/443/quic-v1/webtransport",
+			"/ip4/1.2.3.4/udp/443/webtransport",
+			"/ip4/1.2.3.4/udp/443/quic-v1/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+		},
+	},
 	"IPFS": {
 		Pattern: IPFS,
 		Good: []string{
@@ -55,16 +77,82 @@ var TestVectors = map[string]*testVector{
 			"/ipfs/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
 		},
 	},
+	"P2PCircuit": {
+		Pattern: P2PCircuit,
+		Good: []string{
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit",
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+			"/ip6/::/udp/1234/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+		},
+		Bad: []string{
+			"/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+			"/ip4/1.2.3.4/tcp/1234/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit",
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		},
+	},
 	"DNS": {
 		Pattern: DNS,
 		Good:    []string{"/dns4/example.io", "/dns6/example.io", "/dns/exmaple.io"},
 		Bad:     []string{"/dnsaddr/example.io", "/ip4/127.0.0.1"},
 	},
+	"Unix": {
+		Pattern: Unix,
+		Good:    []string{"/unix/var/run/ipfs.sock", "/unix/%2Fvar%2Frun%2Fipfs.sock", "/unix/var/run/ipfs.sock/http"},
+		Bad:     []string{"/ip4/1.2.3.4/tcp/5001/http", "/ip4/1.2.3.4/unix/var/run/ipfs.sock"},
+	},
 	"WebRTCDirect": {
 		Pattern: WebRTCDirect,
 		Good:    []string{"/ip4/1.2.3.4/tcp/3456/http/p2p-webrtc-direct", "/ip6/::/tcp/0/http/p2p-webrtc-direct"},
 		Bad:     []string{"/ip4/0.0.0.0", "/ip6/fc00::", "/udp/12345", "/ip6/fc00::/tcp/5523/udp/9543"},
 	},
+	"WebRTCDirect2": {
+		Pattern: WebRTCDirect2,
+		Good: []string{
+			"/ip4/1.2.3.4/udp/1234/webrtc-direct",
+			"/ip6/::/udp/1234/webrtc-direct/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+			"/dns4/example.io/udp/1234/webrtc-direct/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g/certhash/uEiAkH5a4DPGKUuOBjYw0CgwjvcJCJMD2K_1aluKR_tpevQ",
+		},
+		Bad: []string{"/ip4/1.2.3.4/tcp/1234/webrtc-direct", "/ip4/1.2.3.4/tcp/3456/http/p2p-webrtc-direct", "/webrtc-direct"},
+	},
+	"WebRTC": {
+		Pattern: WebRTC,
+		Good: []string{
+			"/ip4/1.2.3.4/udp/1234/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc",
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc",
+			"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+			"/dns4/example.com/tcp/443/wss/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc",
+			"/ip4/1.2.3.4/udp/443/quic-v1/webtransport/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc",
+		},
+		Bad: []string{
+			"/webrtc",
+			"/p2p-circuit/webrtc",
+			"/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit/webrtc",
+			"/ip4/1.2.3.4/udp/1234/webrtc",
+			"/ip4/1.2.3.4/udp/1234/quic-v1/p2p-circuit/webrtc",
+			"/ip4/1.2.3.4/udp/1234/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit",
+		},
+	},
+	"DNSAddr": {
+		Pattern: DNSAddr,
+		Good:    []string{"/dnsaddr/bootstrap.libp2p.io"},
+		Bad:     []string{"/dnsaddr/bootstrap.libp2p.io/tcp/4001", "/dns/bootstrap.libp2p.io"},
+	},
+	"DNSAddrP2P": {
+		Pattern: DNSAddrP2P,
+		Good:    []string{"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN"},
+		Bad:     []string{"/dnsaddr/bootstrap.libp2p.io/tcp/4001/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN", "/dns/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN"},
+	},
+	"Memory": {
+		Pattern: Memory,
+		Good:    []string{"/memory/1234", "/memory/0"},
+		Bad:     []string{"/memory/1234/tcp/80", "/ip4/1.2.3.4/tcp/1234"},
+	},
+	"MemoryP2P": {
+		Pattern: MemoryP2P,
+		Good:    []string{"/memory/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+		Bad:     []string{"/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", "/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+	},
 	"HTTP": {
 		Pattern: HTTP,
 		Good:    []string{"/ip4/1.2.3.4/http", "/dns4/example.io/http", "/dns6/::/tcp/7011/http", "/ip6/fc00::/http"},
@@ -72,8 +160,55 @@ var TestVectors = map[string]*testVector{
 	},
 	"HTTPS": {
 		Pattern: HTTPS,
-		Good:    []string{"/ip4/1.2.3.4/https", "/dns4/example.io/https", "/dns6/::/tcp/7011/https", "/ip6/fc00::/https"},
-		Bad:     []string{"/ip4/1.2.3.4/http", "/ip4/0.0.0.0/tcp/12345/quic", "/ip6/fc00::/tcp/5523"},
+		Good:    []string{"/ip4/1.2.3.4/https", "/dns4/example.io/https", "/dns6/::/tcp/7011/https", "/ip6/fc00::/https", "/dns4/example.io/tcp/443/tls/http"},
+		Bad:     []string{"/ip4/1.2.3.4/http", "/ip4/0.0.0.0/tcp/12345/quic", "/ip6/fc00::/tcp/5523", "/ip4/1.2.3.4/tls/http", "/ip4/1.2.3.4/tcp/443/tls/https"},
+	},
+	"Onion": {
+		Pattern: Onion,
+		Good:    []string{"/onion/timaq4ygg2iegci7:1234", "/onion/aaimaq4ygg2iegci:80"},
+		Bad:     []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234", "/onion/timaq4ygg2iegci7:1234/http"},
+	},
+	"Onion3": {
+		Pattern: Onion3,
+		Good:    []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234"},
+		Bad:     []string{"/onion/timaq4ygg2iegci7:1234", "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:1234/http"},
+	},
+	"OnionHTTP": {
+		Pattern: OnionHTTP,
+		Good:    []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/http", "/onion/timaq4ygg2iegci7:80/http"},
+		Bad:     []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:443/https", "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/tcp/80/http"},
+	},
+	"OnionP2P": {
+		Pattern: OnionP2P,
+		Good:    []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+		Bad:     []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:4001/http/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"},
+	},
+	"SecureTCP": {
+		Pattern: SecureTCP,
+		Good:    []string{"/ip4/1.2.3.4/tcp/443/tls", "/dns4/example.io/tcp/443/tls"},
+		Bad:     []string{"/ip4/1.2.3.4/tcp/443", "/ip4/1.2.3.4/udp/443/tls", "/tls"},
+	},
+	"WS": {
+		Pattern: WS,
+		Good:    []string{"/ip4/1.2.3.4/tcp/80/ws", "/dns4/example.io/tcp/443/ws", "/ip6/::/tcp/0/ws", "/dns6/example.io/ws"},
+		Bad:     []string{"/ip4/1.2.3.4/tcp/80/http", "/ip4/1.2.3.4/tcp/443/wss", "/ip4/1.2.3.4/udp/80/ws", "/ws"},
+	},
+	"WSS": {
+		Pattern: WSS,
+		Good:    []string{"/ip4/1.2.3.4/tcp/443/wss", "/dns4/example.io/tcp/443/wss", "/ip4/1.2.3.4/tcp/443/tls/ws", "/dns4/example.io/tcp/443/tls/ws"},
+		Bad:     []string{"/ip4/1.2.3.4/tcp/443/https", "/ip4/1.2.3.4/tcp/443/ws", "/ip4/1.2.3.4/tls/ws", "/wss"},
+	},
+	"WSSCircuit": {
+		Pattern: WSSCircuit,
+		Good: []string{
+			"/dns4/relay.example.com/tcp/443/wss/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+			"/ip4/1.2.3.4/tcp/443/tls/sni/relay.example.com/ws/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		},
+		Bad: []string{
+			"/dns4/relay.example.com/tcp/443/wss/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC",
+			"/dns4/relay.example.com/tcp/443/wss/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit",
+			"/dns4/relay.example.com/tcp/443/ws/p2p/QmcgpsyWgH8Y8ajJz1Cu72KnS5uo2Aa2LpzU7kinSupNKC/p2p-circuit/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		},
 	},
 }
 
@@ -99,14 +234,789 @@ func TestProtocolMatching(t *testing.T) {
 	}
 }
 
+func TestQUICAny(t *testing.T) {
+	assertMatches(t, QUICAny, TestVectors["QUIC"].Good, TestVectors["QUICV1"].Good)
+	assertMismatches(t, QUICAny, TestVectors["UDP"].Good, TestVectors["UTP"].Good, TestVectors["TCP"].Good, TestVectors["WebTransport"].Good)
+}
+
 func TestReliableGroup(t *testing.T) {
-	assertMatches(t, Reliable, TestVectors["UTP"].Good, TestVectors["TCP"].Good, TestVectors["QUIC"].Good)
-	assertMismatches(t, Reliable, TestVectors["IP"].Good, TestVectors["UDP"].Good, TestVectors["IPFS"].Good)
+	assertMatches(t, Reliable, TestVectors["UTP"].Good, TestVectors["TCP"].Good, TestVectors["QUIC"].Good, TestVectors["QUICV1"].Good)
+	assertMismatches(t, Reliable, TestVectors["IP"].Good, TestVectors["UDP"].Good, TestVectors["IPFS"].Good, TestVectors["Memory"].Good)
+	assertMatches(t, Or(Reliable, Memory), TestVectors["Memory"].Good)
+}
+
+func TestTorReliableGroup(t *testing.T) {
+	assertMatches(t, TorReliable, TestVectors["TCP"].Good, TestVectors["QUICV1"].Good, TestVectors["Onion"].Good, TestVectors["Onion3"].Good)
+	assertMismatches(t, TorReliable, TestVectors["UDP"].Good, TestVectors["OnionHTTP"].Good, TestVectors["OnionP2P"].Good)
+	assertMismatches(t, Reliable, TestVectors["Onion"].Good, TestVectors["Onion3"].Good)
+}
+
+func TestSNI(t *testing.T) {
+	assertMatches(t, HTTPS, []string{"/dns4/example.com/tcp/443/tls/sni/example.com/http"})
+	assertMatches(t, WSS, []string{"/ip4/1.2.3.4/tcp/443/tls/sni/example.com/ws"})
+	assertMismatches(t, HTTPS, []string{"/dns4/example.com/tcp/443/sni/example.com/http", "/dns4/example.com/tcp/443/tls/sni/example.com"})
+
+	pinned := And(SecureTCP, SNIHost("example.com"), Base(ma.P_HTTP))
+	assertMatches(t, pinned, []string{"/dns4/example.com/tcp/443/tls/sni/example.com/http"})
+	assertMismatches(t, pinned, []string{"/dns4/example.com/tcp/443/tls/sni/example.org/http", "/dns4/example.com/tcp/443/tls/http"})
+}
+
+func TestHTTPPath(t *testing.T) {
+	assertMatches(t, HTTPS, []string{
+		"/dns4/example.com/tcp/443/https/http-path/%2Fmy%2Fapi",
+		"/dns4/example.com/tcp/443/https",
+		"/dns4/example.com/tcp/443/tls/sni/example.com/http/http-path/%2Fmy%2Fapi",
+	})
+	assertMatches(t, HTTP, []string{"/ip4/1.2.3.4/tcp/80/http/http-path/%2F.well-known%2Flibp2p", "/ip4/1.2.3.4/tcp/80/http"})
+	assertMismatches(t, HTTP, []string{
+		"/ip4/1.2.3.4/tcp/80/http-path/%2Fmy%2Fapi",
+		"/ip4/1.2.3.4/tcp/80/http/http-path/%2Fa/http-path/%2Fb",
+		"/dns4/example.com/tcp/443/https/http-path/%2Fmy%2Fapi",
+	})
+}
+
+func TestCertHashes(t *testing.T) {
+	const (
+		quic = "/ip4/1.2.3.4/udp/443/quic-v1"
+		hash = "/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+	)
+	assertMatches(t, And(QUICV1, CertHashes), []string{quic, quic + hash, quic + hash + hash})
+	assertMatches(t, CertHashes, []string{hash, hash + hash})
+	assertMismatches(t, CertHashes, []string{quic})
+
+	// A certhash needs a transport underneath it.
+	for _, p := range []Pattern{WebTransport, WebRTCDirect2} {
+		assertMismatches(t, p, []string{hash, quic + hash, "/ip4/1.2.3.4/udp/443" + hash})
+	}
+}
+
+func TestIPVersions(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Good    string
+		Other   Pattern
+	}{
+		{TCP4, "/ip4/1.2.3.4/tcp/80", TCP6},
+		{TCP6, "/ip6/::1/tcp/80", TCP4},
+		{UDP4, "/ip4/1.2.3.4/udp/80", UDP6},
+		{UDP6, "/ip6/::1/udp/80", UDP4},
+	} {
+		assertMatches(t, tc.Pattern, []string{tc.Good})
+		assertMismatches(t, tc.Other, []string{tc.Good})
+	}
+	for _, p := range []Pattern{TCP4, TCP6, UDP4, UDP6} {
+		assertMismatches(t, p, []string{"/dns/example.com/tcp/80", "/dns4/example.com/udp/80", "/dns6/example.com/tcp/80"})
+	}
+	assertMismatches(t, TCP4, []string{"/ip4/1.2.3.4/udp/80"})
+}
+
+func TestNetworkHost(t *testing.T) {
+	assertMatches(t, NetworkHost, TestVectors["IP"].Good, []string{"/dns/example.com", "/dns4/example.com", "/dns6/example.com"})
+	assertMismatches(t, NetworkHost, []string{"/ip4/1.2.3.4/ip4/1.2.3.4", "/dns/example.com/ip4/1.2.3.4", "/ip4/1.2.3.4/tcp/80", "/tcp/80"})
 }
 
 func TestUnreliableGroup(t *testing.T) {
 	assertMatches(t, Unreliable, TestVectors["UDP"].Good)
-	assertMismatches(t, Unreliable, TestVectors["IP"].Good, TestVectors["TCP"].Good, TestVectors["UTP"].Good, TestVectors["IPFS"].Good, TestVectors["QUIC"].Good)
+	assertMismatches(t, Unreliable, TestVectors["IP"].Good, TestVectors["TCP"].Good, TestVectors["UTP"].Good, TestVectors["IPFS"].Good, TestVectors["QUIC"].Good, TestVectors["QUICV1"].Good)
+
+	// No address is both reliable and unreliable.
+	assertMismatches(t, Reliable, TestVectors["UDP"].Good)
+	if !Unreliable.Equal(UDP) {
+		t.Fatalf("expected Unreliable to be UDP, got %s", Unreliable)
+	}
+}
+
+func TestGarlicValid(t *testing.T) {
+	garlic := func(name string, n int) string {
+		b := make([]byte, n)
+		for i := range b {
+			b[i] = byte(i)
+		}
+		enc := garlic64Encoding.EncodeToString(b)
+		if name == "garlic32" {
+			enc = garlic32Encoding.EncodeToString(b)
+		}
+		c, err := ma.NewComponent(name, enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.String()
+	}
+
+	// go-multiaddr accepts the longer garlic32 values and the 386-byte
+	// garlic64 value, but they are not a hash and a destination.
+	assertMatches(t, GARLIC32, []string{garlic("garlic32", 32), garlic("garlic32", 35)})
+	assertMatches(t, GARLIC32Valid, []string{garlic("garlic32", 32)})
+	assertMismatches(t, GARLIC32Valid, []string{garlic("garlic32", 35), garlic("garlic32", 40)})
+
+	assertMatches(t, GARLIC64, []string{garlic("garlic64", 386), garlic("garlic64", 387)})
+	assertMatches(t, GARLIC64Valid, []string{garlic("garlic64", 387), garlic("garlic64", 516)})
+	assertMismatches(t, GARLIC64Valid, []string{garlic("garlic64", 386)})
+
+	// Truncated values do not make it into a multiaddr at all.
+	if _, err := ma.NewComponent("garlic32", garlic32Encoding.EncodeToString(make([]byte, 20))); err == nil {
+		t.Fatal("expected a truncated garlic32 value to be rejected")
+	}
+	if !GARLIC32Valid.(*basePredicate).pred(garlic32Encoding.EncodeToString(make([]byte, 32))) || GARLIC32Valid.(*basePredicate).pred(garlic32Encoding.EncodeToString(make([]byte, 20))) {
+		t.Fatal("unexpected garlic32 predicate result")
+	}
+}
+
+func TestOnion3HTTP(t *testing.T) {
+	assertMatches(t, Onion3HTTP, []string{"/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/http", "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:8080/http"})
+	assertMismatches(t, Onion3HTTP, []string{"/onion/timaq4ygg2iegci7:80/http", "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80", "/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/tcp/80/http"})
+
+	// The port is part of the onion3 value, so an onion3 component without
+	// one is not a valid multiaddr.
+	if _, err := ma.NewMultiaddr("/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd/http"); err == nil {
+		t.Fatal("expected an onion3 address without a port to be invalid")
+	}
+	if ok, rest := Onion3.PartialMatch(ma.StringCast("/onion3/vww6ybal4bd7szmgncyruucpgfkqahzddi37ktceo3ah7ngmcopnpyyd:80/http")); !ok || len(rest) != 1 {
+		t.Fatalf("expected onion3 to consume a single component, got %v, %v", ok, rest)
+	}
+}
+
+func TestP2P(t *testing.T) {
+	assertMatches(t, P2P, TestVectors["IPFS"].Good, []string{
+		"/ip4/1.2.3.4/tcp/1234/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/ip4/1.2.3.4/udp/1234/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	})
+	assertMismatches(t, P2P, TestVectors["IPFS"].Bad)
+
+	if s := Base(ma.P_P2P).String(); s != "p2p" {
+		t.Fatalf("expected p2p, got %q", s)
+	}
+	if s := P2P.String(); !strings.HasSuffix(s, "}/p2p") || strings.Contains(s, "ipfs") {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestUnixHTTP(t *testing.T) {
+	sock, err := ma.NewComponent("unix", "/var/run/ipfs.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	http, err := ma.NewMultiaddr("/http")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api := sock.Encapsulate(http)
+	if !UnixHTTP.Matches(api) {
+		t.Fatalf("expected %s to match %s", UnixHTTP, api)
+	}
+	if Unix.Matches(api) {
+		t.Fatalf("expected %s not to match %s", Unix, api)
+	}
+
+	// The textual form folds /http into the socket path.
+	assertMismatches(t, UnixHTTP, []string{"/unix/var/run/ipfs.sock/http", "/unix/var/run/ipfs.sock"})
+}
+
+func TestSecure(t *testing.T) {
+	p := Secure(TCP)
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/4001/noise", "/ip4/1.2.3.4/tcp/4001/tls", "/dns4/example.io/tcp/4001/noise"})
+	assertMismatches(t, p, TestVectors["TCP"].Good, []string{"/ip4/1.2.3.4/tcp/4001/noise/tls", "/ip4/1.2.3.4/udp/4001/noise"})
+
+	optional := SecureOptional(TCP)
+	assertMatches(t, optional, TestVectors["TCP"].Good, []string{"/ip4/1.2.3.4/tcp/4001/noise", "/ip4/1.2.3.4/tcp/4001/tls"})
+	assertMismatches(t, optional, []string{"/ip4/1.2.3.4/tcp/4001/noise/tls"})
+
+	ws := And(Secure(TCP), Base(ma.P_WS))
+	assertMatches(t, ws, []string{"/dns4/example.io/tcp/443/tls/ws", "/ip4/1.2.3.4/tcp/443/noise/ws"})
+	assertMismatches(t, ws, []string{"/dns4/example.io/tcp/443/ws"})
+	assertMatches(t, And(SecureOptional(TCP), Base(ma.P_WS)), []string{"/dns4/example.io/tcp/443/tls/ws", "/dns4/example.io/tcp/443/ws"})
+
+	plaintext := "/ip4/1.2.3.4/tcp/4001/plaintextv2"
+	assertMatches(t, p, []string{plaintext}, []string{"/ip6/::1/tcp/4001/plaintextv2"})
+	assertMatches(t, optional, []string{plaintext})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/tcp/4001/plaintextv2/noise", "/ip4/1.2.3.4/udp/4001/plaintextv2"})
+	assertMatches(t, Plaintext, []string{"/plaintextv2"})
+	assertMismatches(t, Plaintext, []string{"/tls", "/ip4/1.2.3.4/tcp/4001/plaintextv2"})
+
+	if s := Secure(Base(ma.P_TCP)).String(); s != "tcp/{tls|noise|plaintextv2}" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := SecureOptional(Base(ma.P_TCP)).String(); s != "tcp/{tls|noise|plaintextv2}?" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertMatches(t, Noise, []string{"/noise"})
+	assertMismatches(t, Noise, []string{"/tls"})
+}
+
+func TestWebsocketComposition(t *testing.T) {
+	p := And(WS, Base(ma.P_P2P))
+	assertMatches(t, p, []string{"/dns4/example.io/tcp/443/ws/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"})
+	assertMismatches(t, p, []string{"/dns4/example.io/tcp/443/http/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"})
+}
+
+func TestOptional(t *testing.T) {
+	p := And(TCP, Optional(Base(ma.P_TLS)), Base(ma.P_HTTP))
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/443/http", "/ip4/1.2.3.4/tcp/443/tls/http"})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/tcp/443/tls", "/ip4/1.2.3.4/tcp/443/noise/http"})
+
+	trailing := And(Base(ma.P_IP4), Base(ma.P_TCP), Optional(Base(ma.P_TLS)))
+	assertMatches(t, trailing, []string{"/ip4/1.2.3.4/tcp/443", "/ip4/1.2.3.4/tcp/443/tls"})
+	assertMismatches(t, trailing, []string{"/ip4/1.2.3.4", "/ip4/1.2.3.4/tcp/443/tls/tls"})
+
+	if s := p.String(); s != "{{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/tcp/tls?/http" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	for _, p := range []Pattern{
+		Optional(And(Base(ma.P_TLS), Base(ma.P_WS))),
+		Optional(And(And(Base(ma.P_TLS), Base(ma.P_WS)))),
+	} {
+		if s := p.String(); s != "(tls/ws)?" {
+			t.Fatalf("unexpected string %q", s)
+		}
+	}
+}
+
+func TestNot(t *testing.T) {
+	p := Not(QUIC)
+	assertMatches(t, p, TestVectors["TCP"].Good, TestVectors["UTP"].Good)
+	assertMismatches(t, p, TestVectors["QUIC"].Good)
+
+	if s := p.String(); s != "!({{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/udp/quic)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	// Not is a terminal check on the rest of the address.
+	notHTTP := And(TCP, Not(Base(ma.P_HTTP)))
+	assertMatches(t, notHTTP, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/tcp/80/ws"})
+	assertMismatches(t, notHTTP, []string{"/ip4/1.2.3.4/tcp/80/http", "/ip4/1.2.3.4/udp/80"})
+	assertMismatches(t, And(Not(Base(ma.P_HTTP)), TCP), TestVectors["TCP"].Good)
+}
+
+func TestRepetition(t *testing.T) {
+	const (
+		peer     = "/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+		circuit  = "/p2p-circuit"
+		circuits = circuit + circuit + circuit
+	)
+
+	plus := And(OneOrMore(Base(ma.P_CIRCUIT)), Base(ma.P_P2P))
+	assertMatches(t, plus, []string{circuit + peer, circuits + peer})
+	assertMismatches(t, plus, []string{peer, circuits, circuit + peer + circuit})
+
+	star := And(ZeroOrMore(Base(ma.P_CIRCUIT)), Base(ma.P_P2P))
+	assertMatches(t, star, []string{peer, circuit + peer, circuits + peer})
+	assertMismatches(t, star, []string{circuits, peer + peer})
+
+	// The greedy repetition has to give back the trailing /p2p.
+	backoff := And(OneOrMore(Or(Base(ma.P_CIRCUIT), Base(ma.P_P2P))), Base(ma.P_P2P))
+	assertMatches(t, backoff, []string{circuit + peer, peer + circuits + peer})
+	assertMismatches(t, backoff, []string{peer, circuits})
+
+	// Repeating a pattern that can match nothing must terminate.
+	assertMatches(t, OneOrMore(Optional(Base(ma.P_CIRCUIT))), []string{circuit, circuits})
+
+	if s := plus.String(); s != "p2p-circuit+/p2p" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := ZeroOrMore(And(Base(ma.P_P2P), Base(ma.P_CIRCUIT))).String(); s != "(p2p/p2p-circuit)*" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	const (
+		peer    = "/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ"
+		circuit = "/p2p-circuit"
+	)
+
+	hops := And(Base(ma.P_P2P), Repeat(Base(ma.P_CIRCUIT), 0, 2))
+	assertMatches(t, hops, []string{peer, peer + circuit, peer + circuit + circuit})
+	assertMismatches(t, hops, []string{peer + circuit + circuit + circuit})
+
+	atLeast := Repeat(Base(ma.P_CIRCUIT), 2, -1)
+	assertMatches(t, atLeast, []string{circuit + circuit, circuit + circuit + circuit + circuit})
+	assertMismatches(t, atLeast, []string{circuit})
+
+	// The longest count leaves nothing for the trailing circuit, so the
+	// repetition has to fall back to a shorter one.
+	backtrack := And(Repeat(Base(ma.P_CIRCUIT), 1, 3), Base(ma.P_CIRCUIT))
+	assertMatches(t, backtrack, []string{circuit + circuit, circuit + circuit + circuit + circuit})
+	assertMismatches(t, backtrack, []string{circuit, circuit + circuit + circuit + circuit + circuit})
+
+	for p, expected := range map[Pattern]string{
+		hops:                              "p2p/p2p-circuit{0,2}",
+		atLeast:                           "p2p-circuit{2,}",
+		Repeat(Base(ma.P_CIRCUIT), 1, -1): "p2p-circuit+",
+	} {
+		if s := p.String(); s != expected {
+			t.Errorf("expected %q, got %q", expected, s)
+		}
+	}
+
+	for _, bounds := range [][2]int{{-1, 2}, {3, 2}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected Repeat(%d, %d) to panic", bounds[0], bounds[1])
+				}
+			}()
+			Repeat(Base(ma.P_CIRCUIT), bounds[0], bounds[1])
+		}()
+	}
+}
+
+func TestAnyOrder(t *testing.T) {
+	const certhash = "/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g"
+
+	annotations := And(Base(ma.P_WEBRTC_DIRECT), AnyOrder(Base(ma.P_CERTHASH), Base(ma.P_P2P)))
+	assertMatches(t, annotations, []string{
+		"/webrtc-direct" + certhash + "/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/webrtc-direct/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ" + certhash,
+	})
+	assertMismatches(t, annotations, []string{
+		"/webrtc-direct" + certhash,
+		"/webrtc-direct/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/webrtc-direct" + certhash + certhash,
+	})
+
+	// Each child can match more than one component.
+	assertMatches(t, AnyOrder(TCP, Base(ma.P_TLS)), []string{"/tls/ip4/1.2.3.4/tcp/443", "/ip4/1.2.3.4/tcp/443/tls"})
+
+	if s := annotations.String(); s != "webrtc-direct/{certhash&p2p}" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestXOr(t *testing.T) {
+	// Both alternatives match a plain TCP address.
+	p := XOr(TCP, And(IP, Base(ma.P_TCP)), UDP)
+	assertMatches(t, p, []string{"/dns/example.com/tcp/80", "/ip4/1.2.3.4/udp/80"})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/udp/80/quic-v1"})
+
+	// Inside an And, only alternatives that let the rest match count.
+	ws := And(XOr(Base(ma.P_TCP), And(Base(ma.P_TCP), Base(ma.P_TLS))), Base(ma.P_WS))
+	assertMatches(t, ws, []string{"/tcp/80/ws", "/tcp/443/tls/ws"})
+	assertMismatches(t, ws, []string{"/tcp/80", "/udp/80/ws"})
+
+	if s := p.String(); s != "{{{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/tcp^{ip4|ip6zone?/ip6}/tcp^{{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/udp}" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if err := p.MatchErr(ma.StringCast("/ip4/1.2.3.4/tcp/80")); err == nil {
+		t.Fatal("expected an error when two alternatives match")
+	}
+
+	ex, err := p.Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ex.String() != "/ip4/127.0.0.1/udp/0" {
+		t.Fatalf("expected an example matching only the first alternative, got %s", ex)
+	}
+}
+
+func TestXOrUpToEnd(t *testing.T) {
+	// Only the first alternative matches /ip4 alone, so wherever the
+	// pattern may stop short of the end of the address, the XOr matches.
+	p := XOr(Base(ma.P_IP4), And(Base(ma.P_IP4), Base(ma.P_TCP)))
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/80")
+	if !p.Matches(a) {
+		t.Fatalf("expected %s to match %s", p, a)
+	}
+	if ok, rem := p.PartialMatch(a); !ok || len(rem) != 0 {
+		t.Fatalf("expected %s to partially match %s, leaving nothing, got %v, %v", p, a, ok, rem)
+	}
+	if ok, _ := p.PartialMatch(ma.StringCast("/ip4/1.2.3.4/udp/80")); !ok {
+		t.Fatalf("expected %s to partially match /ip4 followed by udp", p)
+	}
+	assertMatches(t, Prefix(p), []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/udp/80"})
+	if tail, ok := StripPrefix(p, a); !ok || tail != nil {
+		t.Fatalf("expected %s to strip all of %s, got %v, %v", p, a, tail, ok)
+	}
+
+	// /tls/tls matches both ways in two repetitions, so neither counts.
+	rep, err := Parse("{tls/tls^tls}{0,2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMismatches(t, rep, []string{"/tls/tls", "/tls/tls/tls"})
+	seqs, err := Enumerate(rep)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][]int{{}, {ma.P_TLS}, {ma.P_TLS, ma.P_TLS, ma.P_TLS, ma.P_TLS}}; !slices.EqualFunc(seqs, want, slices.Equal) {
+		t.Fatalf("expected %s to enumerate %v, got %v", rep, want, seqs)
+	}
+
+	// Only tcp leaves room for tcp{1,2} after it when there are three, so
+	// the example synthesized from the alternatives alone does not match.
+	ex, err := Parse("{udp&}/{p2p{0,1}^p2p?^tcp}/tcp{1,2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertMatches(t, ex, []string{"/udp/0/tcp/0/tcp/0/tcp/0"})
+	assertMismatches(t, ex, []string{"/udp/0/tcp/0/tcp/0"})
+	if a, err := ex.Example(); err == nil && !ex.Matches(a) {
+		t.Fatalf("expected the example of %s to match it, got %s", ex, a)
+	}
+}
+
+func TestOrDedup(t *testing.T) {
+	p := OrDedup(TCP, UDP, And(NetworkHost, Base(ma.P_TCP)), TCP, Base(ma.P_IP4))
+	if !p.Equal(Or(TCP, UDP, Base(ma.P_IP4))) {
+		t.Fatalf("expected duplicates to be dropped, got %s", p)
+	}
+	full := Or(TCP, UDP, And(NetworkHost, Base(ma.P_TCP)), TCP, Base(ma.P_IP4))
+	for _, tc := range TestVectors {
+		for _, s := range append(append([]string(nil), tc.Good...), tc.Bad...) {
+			a, err := ma.NewMultiaddr(s)
+			if err != nil {
+				continue
+			}
+			if p.Matches(a) != full.Matches(a) {
+				t.Errorf("%s and %s disagree on %s", p, full, s)
+			}
+		}
+	}
+	if !OrDedup().Equal(Or()) {
+		t.Fatal("expected an empty OrDedup to be an empty Or")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a, b, c, d := Base(ma.P_TCP), Base(ma.P_UDP), Base(ma.P_WS), Base(ma.P_TLS)
+	p := Merge(Or(a, b), Or(c, d))
+	if !p.Equal(Or(a, b, c, d)) {
+		t.Fatalf("expected a flat or, got %s", p)
+	}
+	if n := len(p.(Composite).Children()); n != 4 {
+		t.Fatalf("expected 4 alternatives, got %d", n)
+	}
+	if p := Merge(Or(a, b), Or(b, c)); !p.Equal(Or(a, b, c)) {
+		t.Fatalf("expected duplicates to be dropped, got %s", p)
+	}
+	if p := Merge(Or(a, b), TCP); !p.Equal(Or(a, b, TCP)) {
+		t.Fatalf("expected a non-or to be added as one alternative, got %s", p)
+	}
+
+	// Merging repeatedly keeps the pattern a single level deep.
+	merged := Or()
+	for _, p := range []Pattern{TCP, UDP, Or(WS, WSS), TCP, QUIC} {
+		merged = Merge(merged, p)
+	}
+	if !merged.Equal(Or(TCP, UDP, WS, WSS, QUIC)) || Depth(merged) != Depth(Or(TCP, UDP, WS, WSS, QUIC)) {
+		t.Fatalf("unexpected merged pattern %s", merged)
+	}
+	assertMatches(t, merged, TestVectors["TCP"].Good, TestVectors["WSS"].Good)
+	assertMismatches(t, merged, TestVectors["HTTP"].Good)
+}
+
+func TestAnyBase(t *testing.T) {
+	p := And(AnyBase(ma.P_IP4, ma.P_IP6), AnyBase(ma.P_TCP, ma.P_UDP))
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80", "/ip6/::1/udp/80"})
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4", "/dns/example.com/tcp/80", "/ip4/1.2.3.4/sctp/80"})
+
+	if s := p.String(); s != "{ip4|ip6}/{tcp|udp}" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	or := Or(Base(ma.P_TCP), Base(ma.P_UDP))
+	a := ma.StringCast("/ip4/1.2.3.4/sctp/80")
+	if got, want := p.MatchErr(a), And(IP, or).MatchErr(a); got == nil || want == nil || got.Error() != want.Error() {
+		t.Fatalf("expected error %v, got %v", want, got)
+	}
+	if !AnyBase(ma.P_TCP, ma.P_UDP).Equal(AnyBase(ma.P_TCP, ma.P_UDP)) || AnyBase(ma.P_TCP, ma.P_UDP).Equal(or) {
+		t.Fatal("unexpected equality")
+	}
+	if protos := AnyBase(ma.P_UDP, ma.P_TCP, ma.P_UDP).Protocols(); !slices.Equal(protos, []int{ma.P_TCP, ma.P_UDP}) {
+		t.Fatalf("unexpected protocols %v", protos)
+	}
+	if AnyBase().Matches(ma.StringCast("/tcp/80")) {
+		t.Fatal("an empty any base should match nothing")
+	}
+}
+
+func TestAnyBaseExcept(t *testing.T) {
+	hop := AnyBaseExcept(ma.P_GARLIC32, ma.P_GARLIC64)
+	p := And(TCP, hop)
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80/http", "/ip4/1.2.3.4/tcp/80/tls"})
+	assertMismatches(t, p, []string{
+		"/ip4/1.2.3.4/tcp/80",
+		"/ip4/1.2.3.4/tcp/80/tls/http",
+		"/ip4/1.2.3.4/tcp/80/garlic32/udhdrtrcetjm5sxzskjyr5ztpeszydbh4dpl3pl4utgqqw2v4jna",
+	})
+
+	// It consumes exactly one component, wherever it appears.
+	if ok, rest := hop.PartialMatch(ma.StringCast("/tls/http")); !ok || len(rest) != 1 || rest[0].Code != ma.P_HTTP {
+		t.Fatalf("expected a single component to be consumed, got %v, %v", ok, rest)
+	}
+	assertMatches(t, And(hop, hop, Base(ma.P_TCP)), []string{"/ip4/1.2.3.4/http/tcp/80"})
+	assertMismatches(t, AnyBaseExcept(), []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMatches(t, AnyBaseExcept(), []string{"/ip4/1.2.3.4"})
+
+	if s := p.String(); s != "{{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/tcp/[^garlic32|garlic64]" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if err := p.MatchErr(ma.StringCast("/ip4/1.2.3.4/tcp/80/garlic32/udhdrtrcetjm5sxzskjyr5ztpeszydbh4dpl3pl4utgqqw2v4jna")); err == nil || err.Error() != "expected [^garlic32|garlic64] after tcp but got garlic32 at position 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if hop.Equal(AnyBase(ma.P_GARLIC32, ma.P_GARLIC64)) || !hop.Equal(AnyBaseExcept(ma.P_GARLIC32, ma.P_GARLIC64)) {
+		t.Fatal("unexpected equality")
+	}
+	ex, err := hop.Example()
+	if err != nil || !hop.Matches(ex) {
+		t.Fatalf("unexpected example %v, %v", ex, err)
+	}
+}
+
+func TestAndShortAddress(t *testing.T) {
+	// Fewer components than arguments can still match when some arguments
+	// consume none.
+	p := And(Base(ma.P_IP4), Optional(Base(ma.P_TLS)), Optional(Base(ma.P_NOISE)), Base(ma.P_TCP))
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/tls/tcp/80"})
+	assertMatches(t, And(Base(ma.P_IP4), ZeroOrMore(Base(ma.P_TCP)), Optional(Base(ma.P_TLS))), []string{"/ip4/1.2.3.4"})
+
+	bases := And(Base(ma.P_IP4), Base(ma.P_TCP), Base(ma.P_TLS))
+	assertMismatches(t, bases, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4"})
+	if err := bases.MatchErr(ma.StringCast("/ip4/1.2.3.4/tcp/80")); err == nil || err.Error() != "expected tls after tcp but reached the end of the address at position 2" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestInfer(t *testing.T) {
+	for _, tc := range TestVectors {
+		for _, s := range tc.Good {
+			a := ma.StringCast(s)
+			p := Infer(a)
+			if !p.Matches(a) {
+				t.Errorf("expected %s to match %s", p, a)
+			}
+			var names []string
+			for _, proto := range a.Protocols() {
+				names = append(names, proto.Name)
+			}
+			if p.String() != strings.Join(names, "/") {
+				t.Errorf("expected %s to infer %q, got %q", a, strings.Join(names, "/"), p)
+			}
+		}
+	}
+
+	p := Infer(ma.StringCast("/ip4/1.2.3.4/tcp/443/tls/ws"))
+	if !p.Equal(And(Base(ma.P_IP4), Base(ma.P_TCP), Base(ma.P_TLS), Base(ma.P_WS))) {
+		t.Fatalf("unexpected pattern %s", p)
+	}
+	assertMismatches(t, p, []string{"/ip4/1.2.3.4/tcp/443/ws"})
+}
+
+func TestPartialMatch(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern   Pattern
+		Addr      string
+		Matched   bool
+		Remaining []int
+	}{
+		{TCP, "/ip4/1.2.3.4/tcp/80", true, nil},
+		{TCP, "/ip4/1.2.3.4/tcp/80/http", true, []int{ma.P_HTTP}},
+		{TCP, "/ip4/1.2.3.4/tcp/80/tls/ws", true, []int{ma.P_TLS, ma.P_WS}},
+		{Base(ma.P_IP4), "/ip4/1.2.3.4/udp/80", true, []int{ma.P_UDP}},
+		{TCP, "/ip4/1.2.3.4/udp/80", false, nil},
+		{Base(ma.P_IP6), "/ip4/1.2.3.4", false, nil},
+	} {
+		addr, err := ma.NewMultiaddr(tc.Addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ok, rem := tc.Pattern.PartialMatch(addr)
+		if ok != tc.Matched {
+			t.Errorf("%s against %s: expected matched=%t", tc.Pattern, addr, tc.Matched)
+			continue
+		}
+		if len(rem) != len(tc.Remaining) {
+			t.Errorf("%s against %s: expected %d remaining protocols, got %d", tc.Pattern, addr, len(tc.Remaining), len(rem))
+			continue
+		}
+		for i, p := range rem {
+			if p.Code != tc.Remaining[i] {
+				t.Errorf("%s against %s: expected remaining %v, got %v", tc.Pattern, addr, tc.Remaining, rem)
+				break
+			}
+		}
+	}
+}
+
+func TestEmptyMultiaddr(t *testing.T) {
+	empty := ma.Join()
+	if len(empty.Protocols()) != 0 {
+		t.Fatal("expected an empty multiaddr")
+	}
+
+	if Base(ma.P_IP4).Matches(empty) {
+		t.Error("expected ip4 not to match the empty multiaddr")
+	}
+	if TCP.Matches(empty) {
+		t.Error("expected TCP not to match the empty multiaddr")
+	}
+	if !Optional(Base(ma.P_IP4)).Matches(empty) {
+		t.Error("expected an optional ip4 to match the empty multiaddr")
+	}
+}
+
+func TestEmptyAndOr(t *testing.T) {
+	empty := ma.Join()
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/80")
+
+	// And() matches by consuming nothing.
+	if !And().Matches(empty) || And().Matches(a) {
+		t.Error("expected And() to match only the empty multiaddr")
+	}
+	if ok, rest := And().PartialMatch(a); !ok || len(rest) != 2 {
+		t.Errorf("expected And() to partially match leaving the whole address, got %v, %v", ok, rest)
+	}
+	if err := And().MatchErr(a); err == nil || err.Error() != "expected end of address but got ip4 at position 0" {
+		t.Errorf("unexpected error %v", err)
+	}
+	assertMatches(t, And(TCP, And()), []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMatches(t, And(And(), TCP), []string{"/ip4/1.2.3.4/tcp/80"})
+
+	// Or() never matches.
+	if Or().Matches(empty) || Or().Matches(a) {
+		t.Error("expected Or() to match nothing")
+	}
+	if ok, _ := Or().PartialMatch(a); ok {
+		t.Error("expected Or() not to partially match")
+	}
+	if err := Or().MatchErr(empty); err == nil || err.Error() != "pattern matches no address" {
+		t.Errorf("unexpected error %v", err)
+	}
+	assertMatches(t, Or(TCP, Or()), []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMismatches(t, And(TCP, Or()), []string{"/ip4/1.2.3.4/tcp/80"})
+}
+
+func TestUnknownBaseString(t *testing.T) {
+	if s := Base(99999).String(); s != "<unknown:99999>" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := And(Base(ma.P_IP4), Base(99999)).String(); s != "ip4/<unknown:99999>" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestInvalidOp(t *testing.T) {
+	bad := &pattern{Op: 42}
+	if s := bad.String(); s != "<invalid-op>" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := And(TCP, bad).String(); !strings.HasSuffix(s, "/<invalid-op>") {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertMismatches(t, bad, TestVectors["TCP"].Good)
+	assertMismatches(t, Or(bad, TCP), TestVectors["UDP"].Good)
+	assertMatches(t, Or(bad, TCP), TestVectors["TCP"].Good)
+
+	addr, err := ma.NewMultiaddr("/ip4/1.2.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := bad.PartialMatch(addr); ok {
+		t.Fatal("expected an invalid op not to match")
+	}
+	if err := bad.MatchErr(addr); err == nil {
+		t.Fatal("expected an invalid op to return an error")
+	}
+}
+
+func TestPatternProtocols(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Codes   []int
+	}{
+		{Base(ma.P_TCP), []int{ma.P_TCP}},
+		{DNS, []int{ma.P_DNS, ma.P_DNS4, ma.P_DNS6}},
+		{TCP, []int{ma.P_IP4, ma.P_TCP, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6}},
+		{Reliable, []int{ma.P_IP4, ma.P_TCP, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_UDP, ma.P_UTP, ma.P_QUIC, ma.P_QUIC_V1}},
+		{And(), nil},
+	} {
+		codes := tc.Pattern.Protocols()
+		if len(codes) != len(tc.Codes) {
+			t.Errorf("%s: expected %v, got %v", tc.Pattern, tc.Codes, codes)
+			continue
+		}
+		for i, c := range codes {
+			if c != tc.Codes[i] {
+				t.Errorf("%s: expected %v, got %v", tc.Pattern, tc.Codes, codes)
+				break
+			}
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	for _, tc := range []struct {
+		A, B  Pattern
+		Equal bool
+	}{
+		{Base(ma.P_TCP), Base(ma.P_TCP), true},
+		{Base(ma.P_TCP), Base(ma.P_UDP), false},
+		{Base(ma.P_TCP), And(Base(ma.P_TCP)), false},
+		{TCP, And(Or(IP, DNS), Base(ma.P_TCP)), true},
+		{TCP, UDP, false},
+		{Reliable, Or(TCP, UTP, QUIC, QUICV1), true},
+		{Reliable, Or(TCP, UTP, QUICV1, QUIC), false},
+		{Or(IP, DNS), Or(DNS, IP), false},
+		{And(IP, Base(ma.P_TCP)), Or(IP, Base(ma.P_TCP)), false},
+		{Repeat(IP, 1, 2), Repeat(IP, 1, 2), true},
+		{Repeat(IP, 1, 2), Repeat(IP, 1, 3), false},
+		{OneOrMore(IP), Repeat(IP, 1, -1), true},
+		{Optional(Not(IP)), Optional(Not(IP)), true},
+		{Optional(IP), Not(IP), false},
+	} {
+		if eq := tc.A.Equal(tc.B); eq != tc.Equal {
+			t.Errorf("expected %s.Equal(%s) to be %t", tc.A, tc.B, tc.Equal)
+		}
+		if eq := tc.B.Equal(tc.A); eq != tc.Equal {
+			t.Errorf("expected %s.Equal(%s) to be %t", tc.B, tc.A, tc.Equal)
+		}
+	}
+
+	for name, tc := range TestVectors {
+		if !tc.Pattern.Equal(tc.Pattern) {
+			t.Errorf("expected %s to equal itself", name)
+		}
+	}
+}
+
+func TestComposite(t *testing.T) {
+	c, ok := TCP.(Composite)
+	if !ok {
+		t.Fatal("expected TCP to be a composite pattern")
+	}
+	if c.Operator() != OpAnd {
+		t.Fatalf("expected TCP to be an and, got %s", c.Operator())
+	}
+	if n := len(c.Children()); n != 2 {
+		t.Fatalf("expected TCP to have 2 children, got %d", n)
+	}
+
+	host, ok := c.Children()[0].(Composite)
+	if !ok || host.Operator() != OpOr {
+		t.Fatalf("expected an or, got %s", c.Children()[0])
+	}
+	if !c.Children()[1].Equal(Base(ma.P_TCP)) {
+		t.Fatalf("expected tcp, got %s", c.Children()[1])
+	}
+
+	if _, ok := Pattern(Base(ma.P_TCP)).(Composite); ok {
+		t.Fatal("expected Base not to be a composite pattern")
+	}
+
+	children := c.Children()
+	children[0] = Base(ma.P_UDP)
+	if c.Children()[0].Equal(Base(ma.P_UDP)) {
+		t.Fatal("modifying the children modified the pattern")
+	}
+
+	for op, name := range map[Op]string{OpAnd: "and", OpOr: "or", OpOptional: "optional", OpNot: "not", OpRepeat: "repeat", OpAnyOrder: "any-order", OpXOr: "xor", Op(42): "Op(42)"} {
+		if op.String() != name {
+			t.Errorf("expected %q, got %q", name, op.String())
+		}
+	}
 }
 
 func assertMatches(t *testing.T, p Pattern, args ...[]string) {
@@ -143,3 +1053,246 @@ func assertMismatches(t *testing.T, p Pattern, args ...[]string) {
 		}
 	}
 }
+
+// assertRoundTrip checks that p matches its example, and that it survives
+// a round trip through JSON.
+func assertRoundTrip(t *testing.T, p Pattern) {
+	t.Helper()
+
+	ex, err := p.Example()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Matches(ex) {
+		t.Fatalf("%s does not match its example %s", p, ex)
+	}
+	if parsed := mustParseJSON(t, p); !parsed.Equal(p) {
+		t.Fatalf("expected %s, got %s", p, parsed)
+	}
+}
+
+func BenchmarkReliableMatches(b *testing.B) {
+	for _, s := range []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/udp/80/quic-v1"} {
+		a := ma.StringCast(s)
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Reliable.Matches(a)
+			}
+		})
+	}
+}
+
+func BenchmarkAnyBase(b *testing.B) {
+	codes := []int{ma.P_TCP, ma.P_UDP, ma.P_DCCP, ma.P_SCTP, ma.P_QUIC_V1, ma.P_WS}
+	var bases []Pattern
+	for _, c := range codes {
+		bases = append(bases, Base(c))
+	}
+	a := ma.StringCast("/ws")
+	for name, p := range map[string]Pattern{"AnyBase": AnyBase(codes...), "Or": Or(bases...)} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				p.Matches(a)
+			}
+		})
+	}
+}
+
+// generalAnd is an And of ps that always takes the general matching path.
+func generalAnd(ps ...Pattern) Pattern {
+	return &pattern{Op: OpAnd, Args: ps}
+}
+
+func BenchmarkBaseAnd(b *testing.B) {
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/443/tls/sni/example.com/http")
+	bases := []Pattern{Base(ma.P_IP4), Base(ma.P_TCP), Base(ma.P_TLS), Base(ma.P_SNI), Base(ma.P_HTTP)}
+	for name, p := range map[string]Pattern{
+		"Flat":    And(bases...),
+		"General": generalAnd(bases...),
+		"HTTPS":   HTTPS,
+	} {
+		b.Run(name, func(b *testing.B) {
+			// Decode the address once, so that only matching is measured.
+			pcs := components(a)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				p.match(pcs, nil, isEmpty)
+			}
+		})
+	}
+}
+
+func BenchmarkHTTPSMatches(b *testing.B) {
+	for _, s := range []string{"/ip4/1.2.3.4/tcp/443/https", "/ip4/1.2.3.4/tcp/443/tls/sni/example.com/http"} {
+		a := ma.StringCast(s)
+		b.Run(s, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				HTTPS.Matches(a)
+			}
+		})
+	}
+}
+
+func FuzzBaseAnd(f *testing.F) {
+	f.Add([]byte{0, 1}, []byte{0, 1})
+	f.Add([]byte{0, 1, 2}, []byte{0, 1})
+	codes := []int{ma.P_IP4, ma.P_TCP, ma.P_UDP, ma.P_TLS}
+	f.Fuzz(func(t *testing.T, ps, as []byte) {
+		// Nest every other Base in an And of its own.
+		var args []Pattern
+		for i, c := range ps {
+			b := Base(codes[int(c)%len(codes)])
+			if i%2 == 1 {
+				args = append(args, And(b))
+			} else {
+				args = append(args, b)
+			}
+		}
+		var cs []ma.Multiaddr
+		for _, c := range as {
+			code := codes[int(c)%len(codes)]
+			ex, err := example(Base(code))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cs = append(cs, ex...)
+		}
+		a := ma.Join(cs...)
+
+		flat, general := And(args...), generalAnd(args...)
+		if flat.(*pattern).codes == nil {
+			t.Fatalf("expected %s to take the fast path", flat)
+		}
+		if flat.Matches(a) != general.Matches(a) {
+			t.Fatalf("fast path for %s disagrees on %s", flat, a)
+		}
+		if errA, errB := flat.MatchErr(a), general.MatchErr(a); (errA == nil) != (errB == nil) || (errA != nil && errA.Error() != errB.Error()) {
+			t.Fatalf("fast path for %s gives error %v, expected %v", flat, errA, errB)
+		}
+	})
+}
+
+func TestIP6Zone(t *testing.T) {
+	scoped := "/ip6zone/eth0/ip6/fe80::1/tcp/4001"
+	assertMatches(t, TCP, []string{scoped, "/ip6/fe80::1/tcp/4001"})
+	assertMatches(t, TCP6, []string{scoped})
+	assertMatches(t, IP6, []string{"/ip6zone/eth0/ip6/fe80::1", "/ip6/::1"})
+	assertMatches(t, IP, []string{"/ip6zone/eth0/ip6/fe80::1", "/ip6/::1", "/ip4/1.2.3.4"})
+	assertMismatches(t, IP, []string{"/ip6zone/eth0", "/ip6zone/eth0/ip4/1.2.3.4", "/ip6zone/eth0/ip6zone/eth1/ip6/fe80::1"})
+	assertMismatches(t, TCP4, []string{scoped})
+	assertMatches(t, IP6Zone, []string{"/ip6zone/eth0"})
+
+	if s := IP6.String(); s != "ip6zone?/ip6" {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestIPRanges(t *testing.T) {
+	assertMatches(t, Loopback, []string{"/ip4/127.0.0.1", "/ip4/127.255.0.1", "/ip6/::1"})
+	assertMismatches(t, Loopback, []string{"/ip4/10.0.0.1", "/ip4/1.2.3.4", "/ip6/::2", "/dns4/localhost"})
+
+	assertMatches(t, PrivateIP, []string{"/ip4/10.0.0.1", "/ip4/172.16.5.4", "/ip4/192.168.1.1", "/ip6/fd00::1"})
+	assertMismatches(t, PrivateIP, []string{"/ip4/127.0.0.1", "/ip6/::1", "/ip4/172.32.0.1", "/ip4/8.8.8.8", "/ip6/2001:db8::1"})
+
+	assertMatches(t, PublicIP, []string{"/ip4/8.8.8.8", "/ip4/1.2.3.4", "/ip6/2606:4700::1111"})
+	assertMismatches(t, PublicIP, []string{
+		"/ip4/127.0.0.1",
+		"/ip6/::1",
+		"/ip4/10.0.0.1",
+		"/ip6/fd00::1",
+		"/ip4/169.254.1.1",
+		"/ip6/fe80::1",
+		"/ip4/0.0.0.0",
+		"/ip4/224.0.0.1",
+		"/ip4/8.8.8.8/tcp/80",
+	})
+
+	p := And(Loopback, Base(ma.P_TCP))
+	assertMatches(t, p, []string{"/ip4/127.0.0.1/tcp/8080", "/ip6/::1/tcp/8080"})
+	assertMismatches(t, p, []string{"/ip4/10.0.0.1/tcp/8080", "/ip4/8.8.8.8/tcp/8080", "/ip4/127.0.0.1/udp/8080"})
+
+	for _, p := range []Pattern{Loopback, PrivateIP} {
+		ex, err := p.Example()
+		if err != nil {
+			t.Fatalf("no example for %s: %s", p, err)
+		}
+		if !p.Matches(ex) {
+			t.Fatalf("%s does not match its example %s", p, ex)
+		}
+	}
+}
+
+func TestBaseNamed(t *testing.T) {
+	for name, code := range map[string]int{"tcp": ma.P_TCP, "ip4": ma.P_IP4, "p2p-circuit": ma.P_CIRCUIT} {
+		p, err := BaseNamed(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Equal(Base(code)) {
+			t.Fatalf("expected %s to name %s, got %s", name, Base(code), p)
+		}
+	}
+	tcp, _ := BaseNamed("tcp")
+	ip4, _ := BaseNamed("ip4")
+	assertMatches(t, And(ip4, tcp), []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMismatches(t, And(ip4, tcp), []string{"/ip6/::1/tcp/80"})
+
+	p, err := BaseNamed("carrier-pigeon")
+	if err == nil || err.Error() != `unknown protocol "carrier-pigeon"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if p != nil {
+		t.Fatalf("expected no pattern, got %s", p)
+	}
+	if _, err := BaseNamed(""); err == nil {
+		t.Fatal("expected an error for an empty name")
+	}
+}
+
+func TestSingleWay(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Single  bool
+	}{
+		{TCP, true},
+		{Reliable, true},
+		{Unreliable, true},
+		{WSS, true},
+		{And(Optional(Base(ma.P_IP6ZONE)), Base(ma.P_IP6)), true},
+		{Or(Base(ma.P_TCP), Base(ma.P_TCP)), false},
+		{Or(And(Base(ma.P_IP4), Base(ma.P_TCP)), Base(ma.P_IP4)), false},
+		{And(Base(ma.P_IP4), Optional(Base(ma.P_TCP))), false},
+		{And(Repeat(Base(ma.P_P2P), 0, 2), Base(ma.P_CIRCUIT)), false},
+	} {
+		if single := tc.Pattern.(*pattern).single; single != tc.Single {
+			t.Errorf("%s: expected single=%t, got %t", tc.Pattern, tc.Single, single)
+		}
+	}
+
+	// Matching without backtracking must agree with the general matcher,
+	// which the copies mapTree makes fall back to.
+	for name, tv := range TestVectors {
+		general := mapTree(tv.Pattern, func(_, p Pattern) Pattern { return p })
+		for _, s := range append(append([]string{}, tv.Good...), tv.Bad...) {
+			for _, suffix := range []string{"", "/http", "/p2p-circuit"} {
+				addr, err := ma.NewMultiaddr(s + suffix)
+				if err != nil {
+					continue
+				}
+				// Diagnostics always take the continuation path.
+				_, want := MatchCapture(tv.Pattern, addr)
+				if got := tv.Pattern.Matches(addr); got != want {
+					t.Errorf("%s: Matches(%s) = %t, expected %t", name, addr, got, want)
+				}
+				ok, rem := tv.Pattern.PartialMatch(addr)
+				wantOK, wantRem := general.PartialMatch(addr)
+				if ok != wantOK || len(rem) != len(wantRem) {
+					t.Errorf("%s: PartialMatch(%s) = %t, %v, expected %t, %v", name, addr, ok, rem, wantOK, wantRem)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package mafmt
+
+import (
+	"fmt"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Prefix matches addresses that start with an address matched by p, followed
+// by any further components. For example, Prefix(TCP) matches
+// /ip4/1.2.3.4/tcp/80/http. Its PartialMatch behaves like that of p.
+func Prefix(p Pattern) Pattern {
+	return &prefix{inner: p, xor: hasXOr(p)}
+}
+
+type prefix struct {
+	inner Pattern
+	// xor is set if inner contains an XOr, whose alternatives must be
+	// counted up to the end of inner alone.
+	xor bool
+}
+
+func (p *prefix) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *prefix) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *prefix) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *prefix) Equal(other Pattern) bool {
+	o, ok := other.(*prefix)
+	return ok && o.inner.Equal(p.inner)
+}
+
+func (p *prefix) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *prefix) Example() (ma.Multiaddr, error) {
+	return p.inner.Example()
+}
+
+func (p *prefix) partialMatch(pcs []component) (bool, []component) {
+	return p.inner.partialMatch(pcs)
+}
+
+func (p *prefix) match(pcs []component, f *failures, next func([]component) bool) bool {
+	skip := func(rem []component) bool {
+		return skipRest(rem, next)
+	}
+	if p.xor {
+		return matchEnds(p.inner, pcs, f, skip)
+	}
+	return p.inner.match(pcs, f, skip)
+}
+
+// skipRest skips as many of the components of rem as possible, then backs
+// off one at a time until next accepts the rest.
+func skipRest(rem []component, next func([]component) bool) bool {
+	for i := len(rem); i >= 0; i-- {
+		if next(rem[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *prefix) String() string {
+	return format(p, protocolName)
+}
+
+// Suffix matches addresses that end with an address matched by p, preceded
+// by any components. For example, Suffix(Base(ma.P_P2P)) matches any address
+// ending in /p2p/<id>. Matching tries p against every tail of the address, so
+// it makes up to n+1 attempts for an address of n components.
+func Suffix(p Pattern) Pattern {
+	return &suffix{inner: p}
+}
+
+type suffix struct {
+	inner Pattern
+}
+
+func (p *suffix) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *suffix) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *suffix) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *suffix) Equal(other Pattern) bool {
+	o, ok := other.(*suffix)
+	return ok && o.inner.Equal(p.inner)
+}
+
+func (p *suffix) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *suffix) Example() (ma.Multiaddr, error) {
+	return p.inner.Example()
+}
+
+func (p *suffix) partialMatch(pcs []component) (bool, []component) {
+	var rem []component
+	ok := p.match(pcs, nil, func(r []component) bool {
+		rem = r
+		return true
+	})
+	return ok, rem
+}
+
+func (p *suffix) match(pcs []component, f *failures, next func([]component) bool) bool {
+	for i := 0; i <= len(pcs); i++ {
+		if p.inner.match(pcs[i:], f, next) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *suffix) String() string {
+	return format(p, protocolName)
+}
+
+// Contains matches addresses that contain a contiguous run of components
+// matched by p, with any components before and after it. For example,
+// Contains(Base(ma.P_QUIC_V1)) matches any address with a quic-v1 layer.
+// Components matched by p must be adjacent: Contains(And(UDP, Base(ma.P_WS)))
+// does not match /ip4/1.2.3.4/udp/1/quic-v1/ws.
+func Contains(p Pattern) Pattern {
+	return Suffix(Prefix(p))
+}
+
+// Head matches addresses whose first n components are matched by p, followed
+// by any further components, and never matches addresses of fewer than n.
+// For example, Head(2, Or(TCP4, TCP6)) matches /ip4/1.2.3.4/tcp/80 and
+// /ip4/1.2.3.4/tcp/80/http, but not /ip4/1.2.3.4/udp/80. p is matched against
+// the n components alone, so patterns that consume the rest of the address,
+// such as Not and Prefix, only see those. Like Prefix, the components after
+// them are left to whatever follows Head inside an And, and its PartialMatch
+// leaves them unconsumed. It panics if n is negative.
+func Head(n int, p Pattern) Pattern {
+	if n < 0 {
+		panic(fmt.Sprintf("mafmt: Head length must not be negative, got %d", n))
+	}
+	return &head{n: n, inner: p}
+}
+
+type head struct {
+	n     int
+	inner Pattern
+}
+
+func (p *head) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *head) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *head) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *head) Equal(other Pattern) bool {
+	o, ok := other.(*head)
+	return ok && o.n == p.n && o.inner.Equal(p.inner)
+}
+
+func (p *head) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *head) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *head) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) < p.n || !p.inner.match(pcs[:p.n], nil, isEmpty) {
+		return false, nil
+	}
+	return true, pcs[p.n:]
+}
+
+func (p *head) match(pcs []component, f *failures, next func([]component) bool) bool {
+	if len(pcs) < p.n {
+		f.expect(pcs[len(pcs):], fmt.Sprintf("at least %d components", p.n))
+		return false
+	}
+	h := pcs[:p.n]
+	if f == nil {
+		return p.inner.match(h, nil, isEmpty) && skipRest(pcs[p.n:], next)
+	}
+
+	// The head is not a suffix of f.all, so failures within it are
+	// recorded separately and then moved to their place in pcs.
+	inner := &failures{all: h}
+	if !p.inner.match(h, inner, func(rem []component) bool {
+		if len(rem) != 0 {
+			inner.expect(rem, fmt.Sprintf("%d components", p.n))
+			return false
+		}
+		return true
+	}) {
+		for _, e := range inner.expected {
+			f.expect(pcs[inner.pos:], e)
+		}
+		return false
+	}
+	n := len(f.captures)
+	f.captures = append(f.captures, inner.captures...)
+	if skipRest(pcs[p.n:], next) {
+		return true
+	}
+	f.captures = f.captures[:n]
+	return false
+}
+
+func (p *head) String() string {
+	return format(p, protocolName)
+}
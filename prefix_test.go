@@ -0,0 +1,135 @@
+package mafmt
+
+import (
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestPrefix(t *testing.T) {
+	overTCP := Prefix(TCP)
+	assertMatches(t, overTCP, []string{
+		"/ip4/1.2.3.4/tcp/80",
+		"/ip4/1.2.3.4/tcp/80/http",
+		"/dns/example.com/tcp/443/tls/ws",
+	})
+	assertMismatches(t, overTCP, []string{
+		"/ip4/1.2.3.4/udp/80/http",
+		"/tcp/80/http",
+		"/ip4/1.2.3.4",
+	})
+
+	if s := overTCP.String(); s != "({{ip4|ip6zone?/ip6}|{dns|dns4|dns6}}/tcp)/..." {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	a := ma.StringCast("/ip4/1.2.3.4/tcp/80/http")
+	ok, rem := overTCP.PartialMatch(a)
+	if !ok || len(rem) != 1 || rem[0].Code != ma.P_HTTP {
+		t.Fatalf("expected the prefix to leave http unmatched, got %t %v", ok, rem)
+	}
+
+	// Skipped components can still be matched by what follows the prefix.
+	ws := And(Prefix(TCP), Base(ma.P_WS))
+	assertMatches(t, ws, []string{"/ip4/1.2.3.4/tcp/80/ws", "/ip4/1.2.3.4/tcp/80/tls/ws"})
+	assertMismatches(t, ws, []string{"/ip4/1.2.3.4/tcp/80/http"})
+
+	if err := overTCP.MatchErr(ma.StringCast("/ip4/1.2.3.4/udp/80")); err == nil {
+		t.Fatal("expected a match error")
+	}
+
+	assertRoundTrip(t, overTCP)
+}
+
+func TestSuffix(t *testing.T) {
+	peer := Suffix(Base(ma.P_P2P))
+	assertMatches(t, peer, []string{
+		"/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/ip6/::1/udp/4001/quic-v1/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+		"/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ",
+	})
+	assertMismatches(t, peer, []string{
+		"/ip4/1.2.3.4/tcp/4001",
+		"/ip4/1.2.3.4/tcp/4001/p2p/QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ/p2p-circuit",
+	})
+
+	if s := peer.String(); s != ".../p2p" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertRoundTrip(t, peer)
+}
+
+func TestContains(t *testing.T) {
+	quic := Contains(Base(ma.P_QUIC_V1))
+	assertMatches(t, quic, []string{
+		"/ip4/1.2.3.4/udp/443/quic-v1",
+		"/ip4/1.2.3.4/udp/443/quic-v1/webtransport/certhash/uEiDDq4_xNyDorZBH3TlGazyJdOWSwvo4PUo5YHFMrvDE8g",
+		"/quic-v1",
+	})
+	assertMismatches(t, quic, []string{
+		"/ip4/1.2.3.4/udp/443/quic",
+		"/ip4/1.2.3.4/tcp/443/tls/ws",
+	})
+
+	// Contiguous components only.
+	udpWS := Contains(And(UDP, Base(ma.P_WS)))
+	assertMatches(t, udpWS, []string{"/dns/example.com/ip4/1.2.3.4/udp/1/ws/http"})
+	assertMismatches(t, udpWS, []string{"/ip4/1.2.3.4/udp/1/quic-v1/ws"})
+
+	if s := quic.String(); s != ".../quic-v1/..." {
+		t.Fatalf("unexpected string %q", s)
+	}
+}
+
+func TestHead(t *testing.T) {
+	p := Head(2, Or(TCP4, TCP6))
+	// Exactly n components, and more than n with the rest ignored.
+	assertMatches(t, p, []string{"/ip4/1.2.3.4/tcp/80", "/ip6/::1/tcp/443", "/ip4/1.2.3.4/tcp/80/http", "/ip4/1.2.3.4/tcp/80/tls/ws"})
+	// Fewer than n components.
+	assertMismatches(t, p, []string{
+		"/ip4/1.2.3.4",
+		"/ip4/1.2.3.4/udp/80/quic-v1",
+		"/dns/example.com/tcp/80",
+	})
+
+	// p must consume exactly the first n components.
+	assertMismatches(t, Head(3, TCP4), []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/tcp/80/http"})
+	assertMatches(t, Head(1, IP), []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMismatches(t, Head(1, TCP4), []string{"/ip4/1.2.3.4/tcp/80"})
+
+	// Inside an And, as after Prefix, any of the components after the head
+	// can be left to the rest of the And.
+	http := And(Head(2, Or(TCP4, TCP6)), Base(ma.P_HTTP))
+	assertMatches(t, http, []string{"/ip4/1.2.3.4/tcp/80/http", "/ip6/::1/tcp/80/http", "/ip4/1.2.3.4/tcp/80/tls/http"})
+	assertMismatches(t, http, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/udp/80/http"})
+	rest := And(Head(1, IP), Base(ma.P_TCP))
+	assertMatches(t, rest, []string{"/ip4/1.2.3.4/tcp/80"})
+	assertMismatches(t, rest, []string{"/ip4/1.2.3.4/udp/80"})
+
+	// Patterns that consume the rest of the address only see the head.
+	notUDP := And(Head(2, Not(UDP)), Base(ma.P_HTTP))
+	assertMatches(t, notUDP, []string{"/ip4/1.2.3.4/tcp/80/http"})
+	assertMismatches(t, notUDP, []string{"/ip4/1.2.3.4/udp/80/http"})
+	assertMatches(t, And(Head(2, Prefix(IP)), Base(ma.P_HTTP)), []string{"/ip4/1.2.3.4/tcp/80/http"})
+
+	caps, ok := MatchCapture(And(Head(2, And(IP, Capture("port", Base(ma.P_TCP)))), Base(ma.P_HTTP)), ma.StringCast("/ip4/1.2.3.4/tcp/80/http"))
+	if !ok || caps["port"] != "80" {
+		t.Fatalf("unexpected captures %v, %v", caps, ok)
+	}
+	if err := http.MatchErr(ma.StringCast("/ip4/1.2.3.4/udp/80/http")); err == nil || err.Error() != "expected tcp after ip4 but got udp at position 1" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ok, rest := p.PartialMatch(ma.StringCast("/ip4/1.2.3.4/tcp/80/http")); !ok || len(rest) != 1 || rest[0].Code != ma.P_HTTP {
+		t.Fatalf("unexpected partial match %v, %v", ok, rest)
+	}
+	if err := p.MatchErr(ma.StringCast("/ip4/1.2.3.4")); err == nil || err.Error() != "expected at least 2 components after ip4 but reached the end of the address at position 1" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := p.String(); s != "head(2, {ip4/tcp|ip6zone?/ip6/tcp})" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertRoundTrip(t, p)
+}
@@ -0,0 +1,105 @@
+package mafmt
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// registry holds the patterns registered by name. It is safe for concurrent
+// use.
+var registry = struct {
+	sync.RWMutex
+	patterns map[string]Pattern
+}{patterns: map[string]Pattern{
+	"DNS4":          DNS4,
+	"DNS6":          DNS6,
+	"DNS":           DNS,
+	"DNSAddr":       DNSAddr,
+	"DNSAddrP2P":    DNSAddrP2P,
+	"IP6Zone":       IP6Zone,
+	"IP6":           IP6,
+	"IP":            IP,
+	"Loopback":      Loopback,
+	"PrivateIP":     PrivateIP,
+	"PublicIP":      PublicIP,
+	"NetworkHost":   NetworkHost,
+	"TCP":           TCP,
+	"TCP4":          TCP4,
+	"TCP6":          TCP6,
+	"UDP":           UDP,
+	"UDP4":          UDP4,
+	"UDP6":          UDP6,
+	"TLS":           TLS,
+	"Noise":         Noise,
+	"Plaintext":     Plaintext,
+	"SNI":           SNI,
+	"SecureTCP":     SecureTCP,
+	"UTP":           UTP,
+	"QUIC":          QUIC,
+	"QUICV1":        QUICV1,
+	"QUICAny":       QUICAny,
+	"CertHashes":    CertHashes,
+	"WebTransport":  WebTransport,
+	"Unreliable":    Unreliable,
+	"Reliable":      Reliable,
+	"Onion":         Onion,
+	"Onion3":        Onion3,
+	"OnionHTTP":     OnionHTTP,
+	"Onion3HTTP":    Onion3HTTP,
+	"OnionP2P":      OnionP2P,
+	"TorReliable":   TorReliable,
+	"GARLIC32":      GARLIC32,
+	"GARLIC64":      GARLIC64,
+	"GARLIC32Valid": GARLIC32Valid,
+	"GARLIC64Valid": GARLIC64Valid,
+	"P2P":           P2P,
+	"P2PCircuit":    P2PCircuit,
+	"IPFS":          IPFS,
+	"HTTPPath":      HTTPPath,
+	"HTTP":          HTTP,
+	"HTTPS":         HTTPS,
+	"WS":            WS,
+	"WSS":           WSS,
+	"WSSCircuit":    WSSCircuit,
+	"Unix":          Unix,
+	"Memory":        Memory,
+	"MemoryP2P":     MemoryP2P,
+	"UnixHTTP":      UnixHTTP,
+	"WebRTCDirect":  WebRTCDirect,
+	"WebRTCDirect2": WebRTCDirect2,
+	"WebRTC":        WebRTC,
+}}
+
+// Register makes p available to Lookup under name. It returns an error if a
+// pattern is already registered under name. The patterns defined by this
+// package are registered under the names of their variables, such as "TCP".
+func Register(name string, p Pattern) error {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.patterns[name]; ok {
+		return fmt.Errorf("a pattern is already registered as %q", name)
+	}
+	registry.patterns[name] = p
+	return nil
+}
+
+// Lookup returns the pattern registered under name.
+func Lookup(name string) (Pattern, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+	p, ok := registry.patterns[name]
+	return p, ok
+}
+
+// Registered returns the names of all registered patterns, in sorted order.
+func Registered() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	names := make([]string, 0, len(registry.patterns))
+	for name := range registry.patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
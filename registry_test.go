@@ -0,0 +1,60 @@
+package mafmt
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestRegistry(t *testing.T) {
+	if p, ok := Lookup("TCP"); !ok || !p.Equal(TCP) {
+		t.Fatalf("expected TCP to be registered, got %v", p)
+	}
+	if _, ok := Lookup("test-unregistered"); ok {
+		t.Fatal("expected no pattern to be registered")
+	}
+
+	p := And(TCP, Base(ma.P_WS))
+	if err := Register("test-transport", p); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := Lookup("test-transport"); !ok || got != p {
+		t.Fatalf("expected the registered pattern, got %v", got)
+	}
+	if err := Register("test-transport", TCP); err == nil || err.Error() != `a pattern is already registered as "test-transport"` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := Register("TCP", UDP); err == nil {
+		t.Fatal("expected built-in patterns not to be replaceable")
+	}
+
+	names := Registered()
+	if !slices.IsSorted(names) || !slices.Contains(names, "test-transport") || !slices.Contains(names, "WebTransport") {
+		t.Fatalf("unexpected names %v", names)
+	}
+}
+
+func TestRegistryConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("test-concurrent-%d", i)
+			if err := Register(name, TCP); err != nil {
+				t.Error(err)
+			}
+			for j := 0; j < 100; j++ {
+				Lookup("TCP")
+				Registered()
+			}
+			if _, ok := Lookup(name); !ok {
+				t.Errorf("expected %s to be registered", name)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,45 @@
+package mafmt
+
+import (
+	"context"
+	"slices"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// Resolver resolves the DNS components of a multiaddr. It is satisfied by
+// *madns.Resolver from github.com/multiformats/go-multiaddr-dns.
+type Resolver interface {
+	Resolve(ctx context.Context, a ma.Multiaddr) ([]ma.Multiaddr, error)
+}
+
+// ResolvingMatcher matches addresses against a pattern after resolving their
+// DNS components. It is built by WithResolver.
+type ResolvingMatcher struct {
+	p Pattern
+	r Resolver
+}
+
+// WithResolver returns a matcher that resolves addresses with r before
+// matching them against p, so that, for example, a pattern built on IP can
+// check addresses given by hostname.
+func WithResolver(p Pattern, r Resolver) ResolvingMatcher {
+	return ResolvingMatcher{p: p, r: r}
+}
+
+// MatchResolved reports whether any address that a resolves to matches the
+// pattern. Addresses without DNS components are matched as they are, without
+// using the resolver. A name that resolves to no addresses does not match,
+// and resolver errors are returned as they are.
+func (m ResolvingMatcher) MatchResolved(ctx context.Context, a ma.Multiaddr) (bool, error) {
+	if !slices.ContainsFunc(components(a), func(c component) bool {
+		return slices.Contains(dnsCodes, c.Code)
+	}) {
+		return m.p.Matches(a), nil
+	}
+	resolved, err := m.r.Resolve(ctx, a)
+	if err != nil {
+		return false, err
+	}
+	return slices.ContainsFunc(resolved, m.p.Matches), nil
+}
@@ -0,0 +1,72 @@
+package mafmt
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+var errNXDomain = errors.New("no such host")
+
+// mockResolver resolves the hostnames it knows to fixed IP components.
+type mockResolver map[string][]string
+
+func (r mockResolver) Resolve(ctx context.Context, a ma.Multiaddr) ([]ma.Multiaddr, error) {
+	first, rest := ma.SplitFirst(a)
+	ips, ok := r[first.Value()]
+	if !ok {
+		return nil, errNXDomain
+	}
+	var out []ma.Multiaddr
+	for _, ip := range ips {
+		out = append(out, ma.StringCast(ip).Encapsulate(rest))
+	}
+	return out, nil
+}
+
+func TestWithResolver(t *testing.T) {
+	r := mockResolver{
+		"example.com":   {"/ip6/2001:db8::1", "/ip4/10.0.0.1"},
+		"v6.example":    {"/ip6/2001:db8::2"},
+		"empty.example": nil,
+	}
+	m := WithResolver(And(IPInCIDR("10.0.0.0/8"), Base(ma.P_TCP)), r)
+
+	for s, expected := range map[string]bool{
+		"/dns/example.com/tcp/80":   true,
+		"/dns/v6.example/tcp/80":    false,
+		"/dns/empty.example/tcp/80": false,
+		"/dns/example.com/udp/80":   false,
+		"/ip4/10.1.2.3/tcp/80":      true,
+	} {
+		ok, err := m.MatchResolved(context.Background(), ma.StringCast(s))
+		if err != nil {
+			t.Fatalf("%s: %s", s, err)
+		}
+		if ok != expected {
+			t.Errorf("expected %s to match %t, got %t", s, expected, ok)
+		}
+	}
+
+	_, err := m.MatchResolved(context.Background(), ma.StringCast("/dns/missing.example/tcp/80"))
+	if !errors.Is(err, errNXDomain) {
+		t.Fatalf("expected the resolver error, got %v", err)
+	}
+}
+
+// Addresses without DNS components must not reach the resolver.
+func TestWithResolverSkipsIPs(t *testing.T) {
+	m := WithResolver(TCP, failingResolver{})
+	ok, err := m.MatchResolved(context.Background(), ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if err != nil || !ok {
+		t.Fatalf("expected a match without resolving, got %t %v", ok, err)
+	}
+}
+
+type failingResolver struct{}
+
+func (failingResolver) Resolve(ctx context.Context, a ma.Multiaddr) ([]ma.Multiaddr, error) {
+	return nil, errors.New("unexpected resolve of " + a.String())
+}
@@ -0,0 +1,90 @@
+package mafmt
+
+// Schema describes the addresses p accepts as a tree of maps and slices,
+// ready to be marshalled to JSON or YAML for API documentation. Unlike the
+// JSON form of a pattern, which exists to be parsed back, the schema is only
+// descriptive, and its shape is kept stable across releases:
+//
+//   - a single component is {"protocol":"tcp"}, with its constraint, if any,
+//     in "value", "cidr", "min" and "max", "hostname", or "predicate";
+//   - a set of protocols is {"anyOf":[...]} or, for AnyBaseExcept,
+//     {"noneOf":[...]};
+//   - an operator is {"op":"and","args":[...]}, with the bounds of a
+//     repetition in "min" and "max", where an unbounded repetition has no
+//     "max";
+//   - a wrapper such as Prefix is an operator named as in String, such as
+//     {"op":"prefix","args":[...]}, with its parameters alongside.
+//
+// Patterns from outside the package are described by their String form, as
+// {"pattern":"..."}.
+func Schema(p Pattern) interface{} {
+	switch p := p.(type) {
+	case Base:
+		return map[string]interface{}{"protocol": protocolName(int(p))}
+	case *baseValue:
+		return map[string]interface{}{"protocol": protocolName(p.code), "value": p.value}
+	case *basePredicate:
+		return map[string]interface{}{"protocol": protocolName(p.code), "predicate": true}
+	case *ipInCIDR:
+		return map[string]interface{}{"protocol": protocolName(p.code), "cidr": p.net.String()}
+	case *portRange:
+		return map[string]interface{}{"protocol": protocolName(p.code), "min": p.min, "max": p.max}
+	case *dnsName:
+		return map[string]interface{}{"anyOf": schemaNames(dnsCodes), "hostname": p.name}
+	case *anyBase:
+		if p.except {
+			return map[string]interface{}{"noneOf": schemaNames(p.codes)}
+		}
+		return map[string]interface{}{"anyOf": schemaNames(p.codes)}
+	case *prefix:
+		return schemaOp("prefix", p.inner)
+	case *suffix:
+		return schemaOp("suffix", p.inner)
+	case *capture:
+		s := schemaOp("capture", p.inner)
+		s["name"] = p.name
+		return s
+	case *maxComponents:
+		s := schemaOp("maxcomponents", p.inner)
+		s["max"] = p.n
+		return s
+	case *head:
+		s := schemaOp("head", p.inner)
+		s["length"] = p.n
+		return s
+	case *ignoring:
+		s := schemaOp("ignoring", p.inner)
+		s["ignore"] = schemaNames(p.codes)
+		return s
+	case *hostIsIP:
+		return schemaOp("hostip", p.inner)
+	case *pattern:
+		s := schemaOp(p.Op.String(), p.Args...)
+		if p.Op == OpRepeat {
+			s["min"] = p.Min
+			if p.Max >= 0 {
+				s["max"] = p.Max
+			}
+		}
+		return s
+	}
+	return map[string]interface{}{"pattern": p.String()}
+}
+
+// schemaOp describes the operator op applied to args.
+func schemaOp(op string, args ...Pattern) map[string]interface{} {
+	sub := make([]interface{}, len(args))
+	for i, a := range args {
+		sub[i] = Schema(a)
+	}
+	return map[string]interface{}{"op": op, "args": sub}
+}
+
+// schemaNames returns the names of the protocols with codes.
+func schemaNames(codes []int) []interface{} {
+	names := make([]interface{}, len(codes))
+	for i, c := range codes {
+		names[i] = protocolName(c)
+	}
+	return names
+}
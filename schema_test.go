@@ -0,0 +1,67 @@
+package mafmt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSchema(t *testing.T) {
+	s, ok := Schema(HTTPS).(map[string]interface{})
+	if !ok || s["op"] != "or" {
+		t.Fatalf("expected an or, got %v", s)
+	}
+	args, ok := s["args"].([]interface{})
+	if !ok || len(args) != 4 {
+		t.Fatalf("expected 4 alternatives, got %v", s["args"])
+	}
+	first := map[string]interface{}{"op": "and", "args": []interface{}{
+		Schema(TCP),
+		map[string]interface{}{"protocol": "https"},
+		map[string]interface{}{"op": "optional", "args": []interface{}{
+			map[string]interface{}{"protocol": "http-path"},
+		}},
+	}}
+	if !reflect.DeepEqual(args[0], first) {
+		t.Fatalf("expected %v, got %v", first, args[0])
+	}
+
+	data, err := json.Marshal(Schema(TCP))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := `{"args":[{"args":[{"args":[{"protocol":"ip4"},{"args":[{"args":[{"protocol":"ip6zone"}],"op":"optional"},{"protocol":"ip6"}],"op":"and"}],"op":"or"},` +
+		`{"args":[{"protocol":"dns"},{"protocol":"dns4"},{"protocol":"dns6"}],"op":"or"}],"op":"or"},{"protocol":"tcp"}],"op":"and"}`
+	if string(data) != tcp {
+		t.Fatalf("unexpected schema %s", data)
+	}
+}
+
+func TestSchemaForms(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Schema  string
+	}{
+		{BaseWithValue(ma.P_TCP, "443"), `{"protocol":"tcp","value":"443"}`},
+		{PortInRange(ma.P_UDP, 1, 1024), `{"max":1024,"min":1,"protocol":"udp"}`},
+		{IPInCIDR("10.0.0.0/8"), `{"cidr":"10.0.0.0/8","protocol":"ip4"}`},
+		{DNSName("example.com"), `{"anyOf":["dns","dns4","dns6","dnsaddr"],"hostname":"example.com"}`},
+		{AnyBaseExcept(ma.P_GARLIC32), `{"noneOf":["garlic32"]}`},
+		{ZeroOrMore(Base(ma.P_CIRCUIT)), `{"args":[{"protocol":"p2p-circuit"}],"min":0,"op":"repeat"}`},
+		{Repeat(Base(ma.P_CIRCUIT), 1, 2), `{"args":[{"protocol":"p2p-circuit"}],"max":2,"min":1,"op":"repeat"}`},
+		{Capture("port", Base(ma.P_TCP)), `{"args":[{"protocol":"tcp"}],"name":"port","op":"capture"}`},
+		{Head(1, Base(ma.P_IP4)), `{"args":[{"protocol":"ip4"}],"length":1,"op":"head"}`},
+		{IgnoringComponents([]int{ma.P_P2P}, Base(ma.P_IP4)), `{"args":[{"protocol":"ip4"}],"ignore":["p2p"],"op":"ignoring"}`},
+		{Prefix(Base(ma.P_IP4)), `{"args":[{"protocol":"ip4"}],"op":"prefix"}`},
+	} {
+		data, err := json.Marshal(Schema(tc.Pattern))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tc.Schema {
+			t.Errorf("%s: expected schema %s, got %s", tc.Pattern, tc.Schema, data)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package mafmt
+
+import "sort"
+
+// Simplify returns a pattern matching exactly the same multiaddrs as p, with
+// redundant structure removed: nested Ands and Ors are flattened into their
+// parent, single-argument Ands and Ors are replaced by their argument, and
+// duplicate alternatives of an Or are dropped. p itself is not modified.
+func Simplify(p Pattern) Pattern {
+	ptrn, ok := p.(*pattern)
+	if !ok {
+		return p
+	}
+
+	var args []Pattern
+	for _, a := range ptrn.Args {
+		a = Simplify(a)
+		if sub, ok := a.(*pattern); ok && sub.Op == ptrn.Op && (ptrn.Op == OpAnd || ptrn.Op == OpOr) {
+			args = append(args, sub.Args...)
+		} else {
+			args = append(args, a)
+		}
+	}
+
+	if ptrn.Op == OpOr {
+		args = dedup(args)
+	}
+	if len(args) == 1 && (ptrn.Op == OpAnd || ptrn.Op == OpOr) {
+		return args[0]
+	}
+	if ptrn.Op == OpAnd {
+		return And(args...)
+	}
+	return &pattern{
+		Op:     ptrn.Op,
+		Args:   args,
+		Min:    ptrn.Min,
+		Max:    ptrn.Max,
+		single: singleArgs(ptrn.Op, args),
+	}
+}
+
+// dedup removes all but the first of any structurally equal patterns in ps.
+func dedup(ps []Pattern) []Pattern {
+	var out []Pattern
+next:
+	for _, p := range ps {
+		for _, seen := range out {
+			if seen.Equal(p) {
+				continue next
+			}
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// Canonicalize returns a pattern matching exactly the same multiaddrs as p,
+// with the arguments of every Or, XOr and AnyOrder, whose order does not
+// change which addresses they match, sorted by their String form, and the
+// protocols of every AnyBase sorted by code, at any depth, including inside
+// wrappers such as Prefix, Capture and Head. The order of And arguments is
+// kept. Combined with Simplify, patterns that differ only in the order they
+// list alternatives canonicalize to equal patterns:
+//
+//	Canonicalize(Simplify(Or(a, b))).Equal(Canonicalize(Simplify(Or(b, a))))
+//
+// Reordering alternatives can change which one PartialMatch, WhichOr and
+// Example pick. p itself is not modified.
+func Canonicalize(p Pattern) Pattern {
+	// mapTree canonicalizes the children of each pattern, including those
+	// of wrappers such as Prefix and Capture, before the pattern itself.
+	return mapTree(p, func(_, p Pattern) Pattern {
+		return canonicalize(p)
+	})
+}
+
+// canonicalize sorts the arguments of p, whose own arguments are already
+// canonical.
+func canonicalize(p Pattern) Pattern {
+	if b, ok := p.(*anyBase); ok {
+		codes := uniqueSorted(append([]int(nil), b.codes...))
+		if b.except {
+			return AnyBaseExcept(codes...)
+		}
+		return AnyBase(codes...)
+	}
+	ptrn, ok := p.(*pattern)
+	if !ok {
+		return p
+	}
+
+	args := ptrn.Args
+	switch ptrn.Op {
+	case OpOr, OpXOr, OpAnyOrder:
+		sort.SliceStable(args, func(i, j int) bool {
+			return args[i].String() < args[j].String()
+		})
+	case OpAnd:
+		return And(args...)
+	}
+	return &pattern{
+		Op:     ptrn.Op,
+		Args:   args,
+		Min:    ptrn.Min,
+		Max:    ptrn.Max,
+		single: singleArgs(ptrn.Op, args),
+	}
+}
@@ -0,0 +1,115 @@
+package mafmt
+
+import (
+	"slices"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestSimplify(t *testing.T) {
+	ip4, ip6, tcp := Base(ma.P_IP4), Base(ma.P_IP6), Base(ma.P_TCP)
+
+	for _, tc := range []struct {
+		In, Out Pattern
+	}{
+		{ip4, ip4},
+		{Or(ip4), ip4},
+		{And(ip4), ip4},
+		{Or(Or(ip4, ip6)), Or(ip4, ip6)},
+		{Or(ip4, Or(ip6, ip4)), Or(ip4, ip6)},
+		{And(And(ip4, tcp), And()), And(ip4, tcp)},
+		{And(Or(ip4, ip4), tcp), And(ip4, tcp)},
+		{Or(And(ip4, tcp), And(ip4, And(tcp))), And(ip4, tcp)},
+		{Optional(Or(ip4)), Optional(ip4)},
+		{Repeat(And(Or(ip4), tcp), 1, 2), Repeat(And(ip4, tcp), 1, 2)},
+		{Or(And(ip4, Or(tcp)), Not(And(ip6))), Or(And(ip4, tcp), Not(ip6))},
+		{UDP, And(Or(ip4, And(Optional(Base(ma.P_IP6ZONE)), ip6), Base(ma.P_DNS), Base(ma.P_DNS4), Base(ma.P_DNS6)), Base(ma.P_UDP))},
+	} {
+		if out := Simplify(tc.In); !out.Equal(tc.Out) {
+			t.Errorf("expected %s to simplify to %s, got %s", tc.In, tc.Out, out)
+		}
+	}
+}
+
+func TestSimplifyEquivalence(t *testing.T) {
+	var addrs []ma.Multiaddr
+	for _, tc := range TestVectors {
+		for _, s := range append(tc.Good, tc.Bad...) {
+			addr, err := ma.NewMultiaddr(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			addrs = append(addrs, addr)
+		}
+	}
+
+	patterns := []Pattern{
+		Reliable,
+		Unreliable,
+		TorReliable,
+		Or(TCP, Or(TCP, UDP), And(Or(IP), Base(ma.P_UDP))),
+		And(And(IP), And(Base(ma.P_TCP), Optional(Or(Base(ma.P_TLS), Base(ma.P_TLS))))),
+	}
+	for _, tc := range TestVectors {
+		patterns = append(patterns, tc.Pattern)
+	}
+
+	for _, p := range patterns {
+		simple := Simplify(p)
+		canonical := Canonicalize(p)
+		for _, addr := range addrs {
+			if p.Matches(addr) != simple.Matches(addr) {
+				t.Errorf("%s and its simplification %s disagree on %s", p, simple, addr)
+			}
+			if p.Matches(addr) != canonical.Matches(addr) {
+				t.Errorf("%s and its canonical form %s disagree on %s", p, canonical, addr)
+			}
+		}
+	}
+}
+
+func TestCanonicalize(t *testing.T) {
+	ip4, ip6, tcp, udp := Base(ma.P_IP4), Base(ma.P_IP6), Base(ma.P_TCP), Base(ma.P_UDP)
+
+	for _, tc := range []struct {
+		A, B Pattern
+	}{
+		{Or(ip4, ip6), Or(ip6, ip4)},
+		{Or(TCP, UDP), Or(UDP, TCP)},
+		{And(Or(ip6, ip4), Or(udp, tcp)), And(Or(ip4, ip6), Or(tcp, udp))},
+		{Optional(Or(tcp, udp)), Optional(Or(udp, tcp))},
+		{XOr(tcp, udp), XOr(udp, tcp)},
+		{AnyOrder(tcp, udp), AnyOrder(udp, tcp)},
+		{AnyBase(ma.P_UDP, ma.P_TCP, ma.P_UDP), AnyBase(ma.P_TCP, ma.P_UDP)},
+		// Ors inside wrappers are sorted too.
+		{Prefix(Or(tcp, udp)), Prefix(Or(udp, tcp))},
+		{Suffix(Or(tcp, udp)), Suffix(Or(udp, tcp))},
+		{Capture("t", Or(tcp, udp)), Capture("t", Or(udp, tcp))},
+		{MaxComponents(2, Or(tcp, udp)), MaxComponents(2, Or(udp, tcp))},
+		{Head(1, Or(tcp, udp)), Head(1, Or(udp, tcp))},
+		{IgnoringComponents([]int{ma.P_P2P}, Or(tcp, udp)), IgnoringComponents([]int{ma.P_P2P}, Or(udp, tcp))},
+		{HostIsIP(Or(TCP, UDP)), HostIsIP(Or(UDP, TCP))},
+		{And(ip4, Prefix(Or(ip6, AnyBase(ma.P_UDP, ma.P_TCP)))), And(ip4, Prefix(Or(AnyBase(ma.P_TCP, ma.P_UDP), ip6)))},
+	} {
+		if a, b := Canonicalize(tc.A), Canonicalize(tc.B); !a.Equal(b) {
+			t.Errorf("expected %s and %s to canonicalize equally, got %s and %s", tc.A, tc.B, a, b)
+		}
+	}
+
+	// And order is significant.
+	if Canonicalize(And(tcp, udp)).Equal(Canonicalize(And(udp, tcp))) {
+		t.Error("expected And order to be kept")
+	}
+	// Canonical Ands of Bases keep the flat fast path, and so do Ands
+	// built on them.
+	flat := Canonicalize(And(ip4, And(tcp, Base(ma.P_WS))))
+	if codes := flat.(*pattern).codes; !slices.Equal(codes, []int{ma.P_IP4, ma.P_TCP, ma.P_WS}) {
+		t.Errorf("expected the canonical and to be flattened, got %v", codes)
+	}
+	// Simplify first to also ignore how alternatives are nested.
+	a, b := Or(ip4, Or(tcp, ip6)), Or(Or(ip6, ip4), tcp)
+	if Canonicalize(a).Equal(Canonicalize(b)) || !Canonicalize(Simplify(a)).Equal(Canonicalize(Simplify(b))) {
+		t.Errorf("unexpected canonical forms %s and %s", Canonicalize(Simplify(a)), Canonicalize(Simplify(b)))
+	}
+}
@@ -0,0 +1,173 @@
+package mafmt
+
+import (
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// MatchTrace is a node in the trace of matching a pattern against an
+// address, built by Trace. The trace mirrors the pattern tree: the children
+// of an And, Or or Optional, and the pattern wrapped by a Capture, have nodes
+// of their own, while other patterns are traced as leaves.
+type MatchTrace struct {
+	// Pattern is the pattern this node traces.
+	Pattern Pattern
+	// Matched reports whether Pattern matched at its position in the
+	// address. For the root of the trace, it reports whether the pattern
+	// matched the whole address.
+	Matched bool
+	// Components are the indices of the components Pattern consumed, in
+	// order.
+	Components []int
+	// Children are the traces of the sub-patterns of Pattern.
+	Children []*MatchTrace
+}
+
+// Trace matches p against a and returns a trace of which parts of p matched
+// which components of a, for highlighting in address editors and similar
+// interfaces. Where a pattern can match in several ways, the trace follows
+// the one that lets the rest of the pattern match, as Matches does, or
+// failing that, the first. When an argument of an And does not match, the
+// arguments after it are not tried and are reported as unmatched with no
+// components. Like MatchErr, Trace is slower than Matches: it considers every
+// way each sub-pattern can match.
+func Trace(p Pattern, a ma.Multiaddr) *MatchTrace {
+	pcs := components(a)
+	t := trace(p, pcs, len(pcs), isEmpty)
+	t.Matched = p.match(pcs, nil, isEmpty)
+	return t
+}
+
+// trace traces p matching a leading run of pcs, the last of total
+// components, preferring a match whose remainder satisfies want.
+func trace(p Pattern, pcs []component, total int, want func([]component) bool) *MatchTrace {
+	var rems [][]component
+	p.match(pcs, nil, func(rem []component) bool {
+		rems = append(rems, rem)
+		return false
+	})
+
+	t := &MatchTrace{Pattern: p}
+	var rem []component
+	if len(rems) > 0 {
+		t.Matched = true
+		rem = rems[0]
+		for _, r := range rems {
+			if want(r) {
+				rem = r
+				break
+			}
+		}
+		for i := total - len(pcs); i < total-len(rem); i++ {
+			t.Components = append(t.Components, i)
+		}
+	}
+
+	// Children are traced towards the remainder chosen for p.
+	reached := func(r []component) bool {
+		if t.Matched {
+			return len(r) == len(rem)
+		}
+		return want(r)
+	}
+	switch p := p.(type) {
+	case *capture:
+		t.Children = []*MatchTrace{trace(p.inner, pcs, total, reached)}
+	case *pattern:
+		switch p.Op {
+		case OpAnd:
+			t.Children = traceSeq(p.Args, pcs, total, reached)
+		case OpOr, OpOptional:
+			for _, a := range p.Args {
+				t.Children = append(t.Children, trace(a, pcs, total, reached))
+			}
+		}
+	}
+	return t
+}
+
+// traceSeq traces each of ps matching in turn, preferring matches after
+// which the rest of ps can match with a remainder satisfying want.
+func traceSeq(ps []Pattern, pcs []component, total int, want func([]component) bool) []*MatchTrace {
+	var ts []*MatchTrace
+	for i, p := range ps {
+		if len(ts) > 0 && !ts[len(ts)-1].Matched {
+			ts = append(ts, &MatchTrace{Pattern: p})
+			continue
+		}
+		rest := ps[i+1:]
+		t := trace(p, pcs, total, func(rem []component) bool {
+			return matchSeq(rest, rem, nil, want)
+		})
+		ts = append(ts, t)
+		pcs = pcs[len(t.Components):]
+	}
+	return ts
+}
+
+// Classify matches p against a and, if it matches, returns the pattern that
+// consumed each component of a, indexed like the components. Each entry is
+// the innermost sub-pattern of p covering the component: a leaf such as a Base
+// or IPInCIDR, or a pattern that consumes components no leaf accounts for,
+// such as Prefix for the components after its prefix, or Not. Where p can
+// match in several ways, Classify follows the one Matches finds.
+func Classify(p Pattern, a ma.Multiaddr) ([]Pattern, bool) {
+	pcs := components(a)
+	c := &classification{total: len(pcs)}
+	var owners []Pattern
+	matched := mapTree(p, func(orig, p Pattern) Pattern {
+		return &classifier{Pattern: p, orig: orig, c: c}
+	}).match(pcs, nil, func(rem []component) bool {
+		if len(rem) != 0 {
+			return false
+		}
+		owners = c.owners()
+		return true
+	})
+	if !matched {
+		return nil, false
+	}
+	return owners, true
+}
+
+// classification holds the components consumed by each sub-pattern along
+// the match in progress in Classify, innermost first.
+type classification struct {
+	total    int
+	consumed []consumed
+}
+
+// consumed records that p consumed the components from start to end.
+type consumed struct {
+	p          Pattern
+	start, end int
+}
+
+// owners returns the innermost pattern that consumed each component.
+func (c *classification) owners() []Pattern {
+	owners := make([]Pattern, c.total)
+	for _, e := range c.consumed {
+		for i := e.start; i < e.end; i++ {
+			if owners[i] == nil {
+				owners[i] = e.p
+			}
+		}
+	}
+	return owners
+}
+
+// classifier records what its pattern consumes in c, on behalf of orig,
+// the pattern it was copied from. Only its match method is used.
+type classifier struct {
+	Pattern
+	orig Pattern
+	c    *classification
+}
+
+func (p *classifier) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return p.Pattern.match(pcs, f, func(rem []component) bool {
+		p.c.consumed = append(p.c.consumed, consumed{p: p.orig, start: p.c.total - len(pcs), end: p.c.total - len(rem)})
+		ok := next(rem)
+		p.c.consumed = p.c.consumed[:len(p.c.consumed)-1]
+		return ok
+	})
+}
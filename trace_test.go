@@ -0,0 +1,120 @@
+package mafmt
+
+import (
+	"slices"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestTrace(t *testing.T) {
+	tr := Trace(TCP, ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if !tr.Matched || !slices.Equal(tr.Components, []int{0, 1}) || len(tr.Children) != 2 {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+	if host := tr.Children[0]; !host.Matched || !host.Pattern.Equal(NetworkHost) || !slices.Equal(host.Components, []int{0}) {
+		t.Fatalf("unexpected host trace %+v", host)
+	}
+	if tcp := tr.Children[1]; !tcp.Matched || !slices.Equal(tcp.Components, []int{1}) {
+		t.Fatalf("unexpected tcp trace %+v", tcp)
+	}
+
+	// A prefix match is not a match of the whole address.
+	if tr := Trace(TCP, ma.StringCast("/ip4/1.2.3.4/tcp/80/http")); tr.Matched || !slices.Equal(tr.Components, []int{0, 1}) {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+}
+
+func TestTraceHTTPS(t *testing.T) {
+	// /ws where /http should be.
+	tr := Trace(HTTPS, ma.StringCast("/ip4/1.2.3.4/tcp/443/tls/ws"))
+	if tr.Matched || len(tr.Children) != 4 {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+	secure := tr.Children[3]
+	if secure.Matched || len(secure.Components) != 0 || len(secure.Children) != 4 {
+		t.Fatalf("unexpected trace %+v", secure)
+	}
+	if c := secure.Children[0]; !c.Matched || !c.Pattern.Equal(SecureTCP) || !slices.Equal(c.Components, []int{0, 1, 2}) {
+		t.Fatalf("expected secure tcp to match, got %+v", c)
+	}
+	if c := secure.Children[1]; !c.Matched || len(c.Components) != 0 {
+		t.Fatalf("expected the sni to be left out, got %+v", c)
+	}
+	if c := secure.Children[2]; c.Matched || !c.Pattern.Equal(Base(ma.P_HTTP)) {
+		t.Fatalf("expected http to be the failing node, got %+v", c)
+	}
+	if c := secure.Children[3]; c.Matched || len(c.Components) != 0 {
+		t.Fatalf("expected the http-path not to be tried, got %+v", c)
+	}
+}
+
+func TestTraceBacktracking(t *testing.T) {
+	circuit := Base(ma.P_CIRCUIT)
+	tr := Trace(And(ZeroOrMore(circuit), circuit), ma.StringCast("/p2p-circuit/p2p-circuit"))
+	if !tr.Matched || !slices.Equal(tr.Children[0].Components, []int{0}) || !slices.Equal(tr.Children[1].Components, []int{1}) {
+		t.Fatalf("expected the trace to follow the successful match, got %+v %+v", tr.Children[0], tr.Children[1])
+	}
+
+	// Arguments after a failing one are not tried.
+	tr = Trace(And(Base(ma.P_IP4), Base(ma.P_UDP), Base(ma.P_QUIC_V1)), ma.StringCast("/ip4/1.2.3.4/tcp/80"))
+	if tr.Matched || !tr.Children[0].Matched || tr.Children[1].Matched || tr.Children[2].Matched {
+		t.Fatalf("unexpected trace %+v", tr)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		Addr   string
+		Leaves []Pattern
+	}{
+		{"/ip4/1.2.3.4/tcp/443/https", []Pattern{Base(ma.P_IP4), Base(ma.P_TCP), Base(ma.P_HTTPS)}},
+		{"/dns4/example.com/https/http-path/api", []Pattern{Base(ma.P_DNS4), Base(ma.P_HTTPS), HTTPPath}},
+		{"/dns4/example.com/tcp/443/tls/sni/example.com/http", []Pattern{Base(ma.P_DNS4), Base(ma.P_TCP), TLS, SNI, Base(ma.P_HTTP)}},
+		{"/ip6zone/eth0/ip6/fe80::1/tcp/443/https", []Pattern{IP6Zone, Base(ma.P_IP6), Base(ma.P_TCP), Base(ma.P_HTTPS)}},
+	} {
+		leaves, ok := Classify(HTTPS, ma.StringCast(tc.Addr))
+		if !ok {
+			t.Fatalf("expected %s to match", tc.Addr)
+		}
+		if len(leaves) != len(tc.Leaves) {
+			t.Fatalf("%s: expected %v, got %v", tc.Addr, tc.Leaves, leaves)
+		}
+		for i, l := range leaves {
+			if !l.Equal(tc.Leaves[i]) {
+				t.Errorf("%s: expected component %d to be classified as %s, got %s", tc.Addr, i, tc.Leaves[i], l)
+			}
+		}
+	}
+	if leaves, ok := Classify(HTTPS, ma.StringCast("/ip4/1.2.3.4/tcp/443/ws")); ok || leaves != nil {
+		t.Fatalf("expected no classification, got %v", leaves)
+	}
+}
+
+func TestClassifyUncovered(t *testing.T) {
+	// Components that no leaf consumes belong to the pattern that does.
+	private := IPInCIDR("10.0.0.0/8")
+	p := Prefix(And(private, Base(ma.P_TCP)))
+	leaves, ok := Classify(p, ma.StringCast("/ip4/10.0.0.1/tcp/80/ws"))
+	if !ok || len(leaves) != 3 || leaves[0] != private || !leaves[1].Equal(Base(ma.P_TCP)) || leaves[2] != p {
+		t.Fatalf("unexpected classification %v", leaves)
+	}
+
+	not := Not(Base(ma.P_UDP))
+	leaves, ok = Classify(And(Base(ma.P_IP4), not), ma.StringCast("/ip4/1.2.3.4/tcp/80/ws"))
+	if !ok || len(leaves) != 3 || !leaves[0].Equal(Base(ma.P_IP4)) || leaves[1] != not || leaves[2] != not {
+		t.Fatalf("unexpected classification %v", leaves)
+	}
+
+	// Backtracking out of a repetition forgets what it consumed.
+	circuits := And(Base(ma.P_IP4), ZeroOrMore(Base(ma.P_CIRCUIT)), Base(ma.P_CIRCUIT), Base(ma.P_CIRCUIT))
+	leaves, ok = Classify(circuits, ma.StringCast("/ip4/1.2.3.4/p2p-circuit/p2p-circuit/p2p-circuit"))
+	if !ok || len(leaves) != 4 {
+		t.Fatalf("unexpected classification %v", leaves)
+	}
+	for i, l := range leaves[1:] {
+		if !l.Equal(Base(ma.P_CIRCUIT)) {
+			t.Errorf("expected component %d to be classified as p2p-circuit, got %s", i+1, l)
+		}
+	}
+}
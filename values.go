@@ -0,0 +1,408 @@
+package mafmt
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// IPInCIDR matches a single ip4 or ip6 component whose address is within the
+// network cidr, such as "10.0.0.0/8". An IPv4 network only matches ip4
+// components, and an IPv6 network only ip6 components. It panics if cidr is
+// not valid CIDR notation.
+func IPInCIDR(cidr string) Pattern {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(fmt.Sprintf("mafmt: invalid CIDR %q: %s", cidr, err))
+	}
+	code := ma.P_IP6
+	if len(ipnet.Mask) == net.IPv4len {
+		code = ma.P_IP4
+	}
+	return &ipInCIDR{code: code, net: ipnet}
+}
+
+type ipInCIDR struct {
+	code int
+	net  *net.IPNet
+}
+
+func (p *ipInCIDR) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *ipInCIDR) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *ipInCIDR) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *ipInCIDR) Equal(other Pattern) bool {
+	o, ok := other.(*ipInCIDR)
+	return ok && o.net.String() == p.net.String()
+}
+
+func (p *ipInCIDR) Protocols() []int {
+	return []int{p.code}
+}
+
+func (p *ipInCIDR) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *ipInCIDR) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 || pcs[0].Code != p.code || !pcs[0].hasValue() {
+		return false, nil
+	}
+	if !p.net.Contains(net.IP(pcs[0].decode().RawValue())) {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *ipInCIDR) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+func (p *ipInCIDR) String() string {
+	return format(p, protocolName)
+}
+
+// PortInRange matches a single component of the port-carrying protocol code,
+// such as ma.P_TCP or ma.P_UDP, whose port is between min and max inclusive.
+// It panics if code does not carry a port or min is greater than max.
+func PortInRange(code, min, max int) Pattern {
+	if ma.ProtocolWithCode(code).Size != 16 {
+		panic(fmt.Sprintf("mafmt: PortInRange protocol %s does not carry a port", Base(code)))
+	}
+	if min > max {
+		panic(fmt.Sprintf("mafmt: PortInRange min %d is greater than max %d", min, max))
+	}
+	return &portRange{code: code, min: min, max: max}
+}
+
+type portRange struct {
+	code, min, max int
+}
+
+func (p *portRange) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *portRange) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *portRange) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *portRange) Equal(other Pattern) bool {
+	o, ok := other.(*portRange)
+	return ok && *o == *p
+}
+
+func (p *portRange) Protocols() []int {
+	return []int{p.code}
+}
+
+func (p *portRange) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *portRange) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 || pcs[0].Code != p.code || !pcs[0].hasValue() {
+		return false, nil
+	}
+	raw := pcs[0].decode().RawValue()
+	if len(raw) != 2 {
+		return false, nil
+	}
+	if port := int(binary.BigEndian.Uint16(raw)); port < p.min || port > p.max {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *portRange) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+func (p *portRange) String() string {
+	return format(p, protocolName)
+}
+
+// BaseWithValue matches a single component of protocol code whose value is
+// value. Values are compared in their canonical form, so
+// BaseWithValue(ma.P_TCP, "0443") matches /tcp/443. It panics if value is not
+// a valid value for the protocol.
+func BaseWithValue(code int, value string) Pattern {
+	p, err := baseWithValue(code, value)
+	if err != nil {
+		panic(fmt.Sprintf("mafmt: %s", err))
+	}
+	return p
+}
+
+func baseWithValue(code int, value string) (*baseValue, error) {
+	c, err := ma.NewComponent(Base(code).String(), value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value %q: %s", Base(code), value, err)
+	}
+	return &baseValue{code: code, value: c.Value()}, nil
+}
+
+type baseValue struct {
+	code  int
+	value string
+}
+
+func (p *baseValue) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *baseValue) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *baseValue) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *baseValue) Equal(other Pattern) bool {
+	o, ok := other.(*baseValue)
+	return ok && *o == *p
+}
+
+func (p *baseValue) Protocols() []int {
+	return []int{p.code}
+}
+
+func (p *baseValue) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *baseValue) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 || pcs[0].Code != p.code || !pcs[0].hasValue() || pcs[0].decode().Value() != p.value {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *baseValue) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+func (p *baseValue) String() string {
+	return format(p, protocolName)
+}
+
+// BaseWithPredicate matches a single component of protocol code whose value,
+// in its canonical string form, satisfies pred.
+func BaseWithPredicate(code int, pred func(value string) bool) Pattern {
+	return &basePredicate{code: code, pred: pred}
+}
+
+type basePredicate struct {
+	code int
+	pred func(string) bool
+}
+
+func (p *basePredicate) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *basePredicate) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *basePredicate) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+// Equal reports whether other is the same predicate pattern; functions cannot
+// be compared, so distinct calls to BaseWithPredicate are never equal.
+func (p *basePredicate) Equal(other Pattern) bool {
+	o, ok := other.(*basePredicate)
+	return ok && o == p
+}
+
+func (p *basePredicate) Protocols() []int {
+	return []int{p.code}
+}
+
+func (p *basePredicate) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *basePredicate) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 || pcs[0].Code != p.code || !pcs[0].hasValue() || !p.pred(pcs[0].decode().Value()) {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *basePredicate) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+func (p *basePredicate) String() string {
+	return format(p, protocolName)
+}
+
+// The encodings of garlic32 and garlic64 values in their string form.
+var (
+	garlic32Encoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+	garlic64Encoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-~")
+)
+
+// garlicLength returns a predicate checking that a value decodes with enc to
+// between min and max bytes, where a negative max means no upper bound.
+func garlicLength(enc interface{ DecodeString(string) ([]byte, error) }, min, max int) func(string) bool {
+	return func(value string) bool {
+		b, err := enc.DecodeString(value)
+		return err == nil && len(b) >= min && (max < 0 || len(b) <= max)
+	}
+}
+
+// dnsCodes are the protocols carrying a hostname, in code order.
+var dnsCodes = []int{ma.P_DNS, ma.P_DNS4, ma.P_DNS6, ma.P_DNSADDR}
+
+// DNSName matches a single dns, dns4, dns6 or dnsaddr component whose hostname
+// is name. Hostnames are compared case-insensitively, ignoring a trailing dot.
+func DNSName(name string) Pattern {
+	return &dnsName{name: strings.TrimSuffix(name, ".")}
+}
+
+type dnsName struct {
+	name string
+}
+
+func (p *dnsName) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *dnsName) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *dnsName) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *dnsName) Equal(other Pattern) bool {
+	o, ok := other.(*dnsName)
+	return ok && strings.EqualFold(o.name, p.name)
+}
+
+func (p *dnsName) Protocols() []int {
+	return append([]int(nil), dnsCodes...)
+}
+
+func (p *dnsName) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *dnsName) partialMatch(pcs []component) (bool, []component) {
+	if len(pcs) == 0 || !slices.Contains(dnsCodes, pcs[0].Code) || !pcs[0].hasValue() {
+		return false, nil
+	}
+	if !strings.EqualFold(strings.TrimSuffix(pcs[0].decode().Value(), "."), p.name) {
+		return false, nil
+	}
+	return true, pcs[1:]
+}
+
+func (p *dnsName) match(pcs []component, f *failures, next func([]component) bool) bool {
+	return matchOne(p, pcs, f, next)
+}
+
+func (p *dnsName) String() string {
+	return format(p, protocolName)
+}
+
+// hostCodes are the protocols an address can start with to name its host,
+// including the ip6zone of a scoped ipv6 host.
+var hostCodes = []int{ma.P_IP4, ma.P_IP6, ma.P_IP6ZONE, ma.P_DNS, ma.P_DNS4, ma.P_DNS6}
+
+// HostIsIP matches the addresses p matches whose first component is an ip4
+// or ip6 host, possibly scoped by an ip6zone, or a dns, dns4 or dns6 host
+// whose name is an IP literal, as in /dns4/127.0.0.1. Combined with a
+// pattern starting with DNS, it catches misconfigured addresses that name an
+// IP as though it were a hostname.
+func HostIsIP(p Pattern) Pattern {
+	return &hostIsIP{inner: p}
+}
+
+type hostIsIP struct {
+	inner Pattern
+}
+
+func (p *hostIsIP) Matches(a ma.Multiaddr) bool {
+	return matches(p, a)
+}
+
+func (p *hostIsIP) PartialMatch(a ma.Multiaddr) (bool, []ma.Protocol) {
+	return partialMatch(p, a)
+}
+
+func (p *hostIsIP) MatchErr(a ma.Multiaddr) error {
+	return matchErr(p, a)
+}
+
+func (p *hostIsIP) Equal(other Pattern) bool {
+	o, ok := other.(*hostIsIP)
+	return ok && o.inner.Equal(p.inner)
+}
+
+func (p *hostIsIP) Protocols() []int {
+	return p.inner.Protocols()
+}
+
+func (p *hostIsIP) Example() (ma.Multiaddr, error) {
+	return exampleAddr(p)
+}
+
+func (p *hostIsIP) partialMatch(pcs []component) (bool, []component) {
+	var rem []component
+	ok := p.match(pcs, nil, func(r []component) bool {
+		rem = r
+		return true
+	})
+	return ok, rem
+}
+
+func (p *hostIsIP) match(pcs []component, f *failures, next func([]component) bool) bool {
+	host := pcs
+	if len(host) > 1 && host[0].Code == ma.P_IP6ZONE && host[1].Code == ma.P_IP6 {
+		host = host[1:]
+	}
+	if len(host) == 0 || !isIPHost(host[0]) {
+		f.expect(pcs, "an IP literal host")
+		return false
+	}
+	return p.inner.match(pcs, f, next)
+}
+
+func (p *hostIsIP) String() string {
+	return format(p, protocolName)
+}
+
+// isIPHost reports whether c is a host component naming an IP address.
+func isIPHost(c component) bool {
+	switch c.Code {
+	case ma.P_IP4, ma.P_IP6:
+		return true
+	case ma.P_DNS, ma.P_DNS4, ma.P_DNS6:
+		return c.hasValue() && net.ParseIP(c.decode().Value()) != nil
+	}
+	return false
+}
@@ -0,0 +1,189 @@
+package mafmt
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestIPInCIDR(t *testing.T) {
+	private := And(IPInCIDR("10.0.0.0/8"), Base(ma.P_TCP))
+	assertMatches(t, private, []string{"/ip4/10.0.0.1/tcp/80", "/ip4/10.255.255.255/tcp/0"})
+	assertMismatches(t, private, []string{
+		"/ip4/11.0.0.1/tcp/80",
+		"/ip4/10.0.0.1/udp/80",
+		"/ip6/::ffff:10.0.0.1/tcp/80",
+		"/dns4/10.0.0.1/tcp/80",
+	})
+
+	ula := IPInCIDR("fc00::/7")
+	assertMatches(t, ula, []string{"/ip6/fc00::1", "/ip6/fdff::1"})
+	assertMismatches(t, ula, []string{"/ip6/fe80::1", "/ip4/10.0.0.1", "/ip6/fc00::1/tcp/80"})
+
+	if s := private.String(); s != "ip4=10.0.0.0/8/tcp" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if !IPInCIDR("10.1.2.3/8").Equal(IPInCIDR("10.0.0.0/8")) || IPInCIDR("10.0.0.0/8").Equal(IPInCIDR("10.0.0.0/16")) {
+		t.Fatal("expected CIDRs to compare by network")
+	}
+
+	assertRoundTrip(t, private)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected an invalid CIDR to panic")
+		}
+	}()
+	IPInCIDR("10.0.0.0")
+}
+
+func TestPortInRange(t *testing.T) {
+	privileged := And(IP, PortInRange(ma.P_TCP, 1, 1024))
+	assertMatches(t, privileged, []string{"/ip4/1.2.3.4/tcp/1", "/ip4/1.2.3.4/tcp/443", "/ip6/::/tcp/1024"})
+	assertMismatches(t, privileged, []string{"/ip4/1.2.3.4/tcp/0", "/ip4/1.2.3.4/tcp/1025", "/ip4/1.2.3.4/udp/443"})
+
+	exact := PortInRange(ma.P_UDP, 4001, 4001)
+	assertMatches(t, exact, []string{"/udp/4001"})
+	assertMismatches(t, exact, []string{"/udp/4000", "/udp/4002", "/tcp/4001"})
+
+	if s := privileged.String(); s != "{ip4|ip6zone?/ip6}/tcp=1-1024" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertRoundTrip(t, privileged)
+
+	for _, args := range [][3]int{{ma.P_TCP, 10, 1}, {ma.P_IP4, 1, 10}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected PortInRange(%d, %d, %d) to panic", args[0], args[1], args[2])
+				}
+			}()
+			PortInRange(args[0], args[1], args[2])
+		}()
+	}
+}
+
+func TestBaseWithValue(t *testing.T) {
+	https := And(IP, BaseWithValue(ma.P_TCP, "443"))
+	assertMatches(t, https, []string{"/ip4/1.2.3.4/tcp/443", "/ip6/::/tcp/443"})
+	assertMismatches(t, https, []string{"/ip4/1.2.3.4/tcp/80", "/ip4/1.2.3.4/udp/443", "/ip4/1.2.3.4/tcp/443/ws"})
+
+	sni := BaseWithValue(ma.P_SNI, "example.com")
+	assertMatches(t, sni, []string{"/sni/example.com"})
+	assertMismatches(t, sni, []string{"/sni/example.org", "/dns/example.com"})
+
+	if !BaseWithValue(ma.P_TCP, "0443").Equal(BaseWithValue(ma.P_TCP, "443")) {
+		t.Fatal("expected values to be compared in canonical form")
+	}
+	if s := https.String(); s != "{ip4|ip6zone?/ip6}/tcp=443" {
+		t.Fatalf("unexpected string %q", s)
+	}
+
+	assertRoundTrip(t, https)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected an invalid value to panic")
+		}
+	}()
+	BaseWithValue(ma.P_TCP, "http")
+}
+
+func TestBaseWithPredicate(t *testing.T) {
+	internal := And(BaseWithPredicate(ma.P_DNS, func(name string) bool {
+		return strings.HasSuffix(name, ".internal")
+	}), Base(ma.P_TCP))
+	assertMatches(t, internal, []string{"/dns/db.internal/tcp/5432"})
+	assertMismatches(t, internal, []string{"/dns/example.com/tcp/5432", "/dns4/db.internal/tcp/5432"})
+
+	even := And(IP, BaseWithPredicate(ma.P_UDP, func(port string) bool {
+		n, err := strconv.Atoi(port)
+		return err == nil && n%2 == 0
+	}))
+	assertMatches(t, even, []string{"/ip4/1.2.3.4/udp/4000", "/ip6/::/udp/0"})
+	assertMismatches(t, even, []string{"/ip4/1.2.3.4/udp/4001", "/ip4/1.2.3.4/tcp/4000"})
+
+	if s := even.String(); s != "{ip4|ip6zone?/ip6}/udp=<pred>" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if ex, err := even.Example(); err != nil || !even.Matches(ex) {
+		t.Fatalf("expected an example matching %s, got %v (%v)", even, ex, err)
+	}
+	if _, err := internal.Example(); err == nil {
+		t.Fatalf("expected no example for %s", internal)
+	}
+	if _, err := json.Marshal(even); err == nil {
+		t.Fatal("expected marshalling a predicate to fail")
+	}
+}
+
+func TestDNSName(t *testing.T) {
+	host := And(DNSName("Example.com."), Base(ma.P_TCP))
+	assertMatches(t, host, []string{
+		"/dns/example.com/tcp/80",
+		"/dns4/EXAMPLE.COM/tcp/80",
+		"/dns6/example.com./tcp/80",
+		"/dnsaddr/eXample.Com/tcp/80",
+	})
+	assertMismatches(t, host, []string{
+		"/dns/example.org/tcp/80",
+		"/dns/www.example.com/tcp/80",
+		"/dns/example.com/dns/example.com/tcp/80",
+		"/ip4/1.2.3.4/tcp/80",
+	})
+
+	if s := host.String(); s != "{dns|dns4|dns6|dnsaddr}=Example.com/tcp" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if s := StringCodes(host); s != "{Base(53)|Base(54)|Base(55)|Base(56)}=Example.com/Base(6)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if !DNSName("example.com").Equal(DNSName("EXAMPLE.com.")) {
+		t.Fatal("expected hostnames to be compared case-insensitively")
+	}
+
+	assertRoundTrip(t, host)
+}
+
+func TestHostIsIP(t *testing.T) {
+	p := HostIsIP(TCP)
+	assertMatches(t, p, []string{
+		"/ip4/127.0.0.1/tcp/80",
+		"/ip6/::1/tcp/80",
+		"/dns4/127.0.0.1/tcp/80",
+		"/dns6/::1/tcp/80",
+		"/dns/10.0.0.1/tcp/80",
+		"/ip6zone/eth0/ip6/fe80::1/tcp/80",
+	})
+	assertMismatches(t, p, []string{
+		"/dns4/example.com/tcp/80",
+		"/dns/localhost/tcp/80",
+		"/ip4/127.0.0.1/udp/80",
+	})
+	assertMatches(t, HostIsIP(DNS4), []string{"/dns4/127.0.0.1"})
+	assertMismatches(t, HostIsIP(DNS4), []string{"/dns4/example.com", "/ip4/127.0.0.1"})
+	assertMatches(t, HostIsIP(IP), []string{"/ip4/127.0.0.1"})
+
+	if err := p.MatchErr(ma.StringCast("/dns4/example.com/tcp/80")); err == nil || !strings.Contains(err.Error(), "expected an IP literal host") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if s := HostIsIP(Base(ma.P_DNS4)).String(); s != "hostip(dns4)" {
+		t.Fatalf("unexpected string %q", s)
+	}
+	if d := Describe(HostIsIP(Base(ma.P_DNS4))); d != "dns4 whose host is an IP literal" {
+		t.Fatalf("unexpected description %q", d)
+	}
+	if !p.Equal(HostIsIP(TCP)) || p.Equal(TCP) {
+		t.Fatal("unexpected equality")
+	}
+
+	if _, err := HostIsIP(DNS4).Example(); err == nil {
+		t.Fatal("expected no example for a dns4 host that is an IP")
+	}
+
+	assertRoundTrip(t, p)
+}
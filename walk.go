@@ -0,0 +1,174 @@
+package mafmt
+
+// Walk traverses the pattern tree rooted at p in pre-order, calling visit on
+// each pattern. The children of a Composite pattern, and the pattern wrapped
+// by Prefix, Suffix, Head, Capture, MaxComponents, IgnoringComponents or
+// HostIsIP, are only visited if visit returned true for it.
+func Walk(p Pattern, visit func(Pattern) bool) {
+	if !visit(p) {
+		return
+	}
+	for _, child := range children(p) {
+		Walk(child, visit)
+	}
+}
+
+// Complexity returns the number of patterns in the tree rooted at p,
+// counting shared sub-patterns once for each place they appear. It can be
+// used to reject overly large patterns built from untrusted input.
+func Complexity(p Pattern) int {
+	n := 0
+	Walk(p, func(Pattern) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Depth returns the nesting depth of the pattern tree rooted at p, where a
+// leaf such as Base has a depth of 1.
+func Depth(p Pattern) int {
+	d := 0
+	for _, child := range children(p) {
+		d = max(d, Depth(child))
+	}
+	return d + 1
+}
+
+// ConsumedLen returns the number of components p consumes, and whether that
+// number is the same for every address p matches, so that callers can size
+// buffers in advance. Patterns that can consume different numbers of
+// components, such as Reliable or ZeroOrMore, or that consume the rest of
+// the address, such as Prefix, Head and Not, are not fixed, and neither is
+// an Or with no alternatives.
+func ConsumedLen(p Pattern) (n int, fixed bool) {
+	switch p := p.(type) {
+	case Base, *ipInCIDR, *portRange, *baseValue, *basePredicate, *dnsName, *anyBase:
+		return 1, true
+	case *capture:
+		return ConsumedLen(p.inner)
+	case *maxComponents:
+		return ConsumedLen(p.inner)
+	case *hostIsIP:
+		return ConsumedLen(p.inner)
+	case *pattern:
+		switch p.Op {
+		case OpAnd, OpAnyOrder:
+			total := 0
+			for _, a := range p.Args {
+				n, ok := ConsumedLen(a)
+				if !ok {
+					return 0, false
+				}
+				total += n
+			}
+			return total, true
+		case OpOr, OpXOr:
+			if len(p.Args) == 0 {
+				return 0, false
+			}
+			n, ok := ConsumedLen(p.Args[0])
+			for _, a := range p.Args[1:] {
+				if m, mok := ConsumedLen(a); !ok || !mok || m != n {
+					return 0, false
+				}
+			}
+			return n, ok
+		case OpOptional:
+			if n, ok := ConsumedLen(p.Args[0]); ok && n == 0 {
+				return 0, true
+			}
+		case OpRepeat:
+			n, ok := ConsumedLen(p.Args[0])
+			switch {
+			case ok && n == 0:
+				return 0, true
+			case ok && p.Min == p.Max:
+				return n * p.Min, true
+			}
+		}
+	}
+	return 0, false
+}
+
+func children(p Pattern) []Pattern {
+	switch p := p.(type) {
+	case Composite:
+		return p.Children()
+	case *prefix:
+		return []Pattern{p.inner}
+	case *suffix:
+		return []Pattern{p.inner}
+	case *capture:
+		return []Pattern{p.inner}
+	case *maxComponents:
+		return []Pattern{p.inner}
+	case *head:
+		return []Pattern{p.inner}
+	case *ignoring:
+		return []Pattern{p.inner}
+	case *hostIsIP:
+		return []Pattern{p.inner}
+	}
+	return nil
+}
+
+// Clone returns a deep copy of p that shares no mutable state with it.
+// Leaves such as Base are immutable and are returned as they are.
+func Clone(p Pattern) Pattern {
+	switch p := p.(type) {
+	case *pattern:
+		args := make([]Pattern, len(p.Args))
+		for i, a := range p.Args {
+			args[i] = Clone(a)
+		}
+		return &pattern{Op: p.Op, Args: args, Min: p.Min, Max: p.Max, codes: p.codes, single: p.single}
+	case *prefix:
+		return &prefix{inner: Clone(p.inner), xor: p.xor}
+	case *suffix:
+		return &suffix{inner: Clone(p.inner)}
+	case *capture:
+		return &capture{name: p.name, inner: Clone(p.inner)}
+	case *maxComponents:
+		return &maxComponents{n: p.n, inner: Clone(p.inner)}
+	case *head:
+		return &head{n: p.n, inner: Clone(p.inner)}
+	case *ignoring:
+		return IgnoringComponents(p.codes, Clone(p.inner))
+	case *hostIsIP:
+		return &hostIsIP{inner: Clone(p.inner)}
+	}
+	return p
+}
+
+// mapTree copies the pattern tree rooted at p, replacing each pattern in it
+// with wrap(orig, p), where orig is the pattern in the tree rooted at p and p
+// is its copy, whose children have been replaced in turn. It lets matching
+// helpers such as MatchWithBudget hook into every step of a match: the
+// copies take no shortcuts, so each of their arguments is matched in turn.
+func mapTree(p Pattern, wrap func(orig, p Pattern) Pattern) Pattern {
+	orig := p
+	switch ptrn := p.(type) {
+	case *pattern:
+		args := make([]Pattern, len(ptrn.Args))
+		for i, a := range ptrn.Args {
+			args[i] = mapTree(a, wrap)
+		}
+		p = &pattern{Op: ptrn.Op, Args: args, Min: ptrn.Min, Max: ptrn.Max}
+	case *prefix:
+		p = &prefix{inner: mapTree(ptrn.inner, wrap), xor: ptrn.xor}
+	case *suffix:
+		p = &suffix{inner: mapTree(ptrn.inner, wrap)}
+	case *capture:
+		p = &capture{name: ptrn.name, inner: mapTree(ptrn.inner, wrap)}
+	case *maxComponents:
+		p = &maxComponents{n: ptrn.n, inner: mapTree(ptrn.inner, wrap)}
+	case *head:
+		p = &head{n: ptrn.n, inner: mapTree(ptrn.inner, wrap)}
+	case *ignoring:
+		p = IgnoringComponents(ptrn.codes, mapTree(ptrn.inner, wrap))
+	case *hostIsIP:
+		p = &hostIsIP{inner: mapTree(ptrn.inner, wrap)}
+	}
+	return wrap(orig, p)
+}
@@ -0,0 +1,147 @@
+package mafmt
+
+import (
+	"strings"
+	"testing"
+
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestWalkOrder(t *testing.T) {
+	p := And(Or(Base(ma.P_IP4), Base(ma.P_IP6)), Optional(Base(ma.P_TCP)))
+
+	var visited []string
+	Walk(p, func(p Pattern) bool {
+		switch p := p.(type) {
+		case Base:
+			visited = append(visited, p.String())
+		case Composite:
+			switch p.Operator() {
+			case OpAnd:
+				visited = append(visited, "and")
+			case OpOr:
+				visited = append(visited, "or")
+			case OpOptional:
+				visited = append(visited, "optional")
+			}
+		}
+		return true
+	})
+
+	if s := strings.Join(visited, " "); s != "and or ip4 ip6 optional tcp" {
+		t.Fatalf("unexpected visit order %q", s)
+	}
+}
+
+func TestWalkSkipsChildren(t *testing.T) {
+	var bases []string
+	Walk(Reliable, func(p Pattern) bool {
+		if c, ok := p.(Composite); ok && c.Operator() == OpAnd && c.Children()[len(c.Children())-1].Equal(Base(ma.P_UTP)) {
+			return false
+		}
+		if b, ok := p.(Base); ok {
+			bases = append(bases, b.String())
+		}
+		return true
+	})
+
+	for _, b := range bases {
+		if b == "utp" {
+			t.Fatal("expected the UTP subtree to be skipped")
+		}
+	}
+	if len(bases) == 0 {
+		t.Fatal("expected the other subtrees to be visited")
+	}
+
+	var count int
+	Walk(TCP, func(Pattern) bool {
+		count++
+		return false
+	})
+	if count != 1 {
+		t.Fatalf("expected only the root to be visited, got %d", count)
+	}
+}
+
+func TestComplexity(t *testing.T) {
+	var nested Pattern = Base(ma.P_TCP)
+	for i := 0; i < 10; i++ {
+		nested = Or(nested, Base(ma.P_UDP))
+	}
+
+	for _, tc := range []struct {
+		Pattern    Pattern
+		Complexity int
+		Depth      int
+	}{
+		{Base(ma.P_TCP), 1, 1},
+		{TCP, 13, 6},
+		{nested, 21, 11},
+		{Contains(Base(ma.P_QUIC_V1)), 3, 3},
+	} {
+		if c := Complexity(tc.Pattern); c != tc.Complexity {
+			t.Errorf("expected %s to have complexity %d, got %d", tc.Pattern, tc.Complexity, c)
+		}
+		if d := Depth(tc.Pattern); d != tc.Depth {
+			t.Errorf("expected %s to have depth %d, got %d", tc.Pattern, tc.Depth, d)
+		}
+	}
+}
+
+func TestConsumedLen(t *testing.T) {
+	for _, tc := range []struct {
+		Pattern Pattern
+		Len     int
+		Fixed   bool
+	}{
+		{Base(ma.P_TCP), 1, true},
+		{TCP4, 2, true},
+		{IP6, 0, false},
+		{TCP, 0, false},
+		{And(TCP4, TLS), 3, true},
+		{AnyOrder(TCP4, Base(ma.P_WS)), 3, true},
+		{Capture("port", Base(ma.P_TCP)), 1, true},
+		{Repeat(Base(ma.P_CIRCUIT), 2, 2), 2, true},
+		{Optional(Repeat(Base(ma.P_CIRCUIT), 0, 0)), 0, true},
+		{Reliable, 0, false},
+		{Optional(TCP), 0, false},
+		{ZeroOrMore(Base(ma.P_CIRCUIT)), 0, false},
+		{Prefix(TCP), 0, false},
+		{Head(2, TCP4), 0, false},
+		{And(Head(2, TCP4), Base(ma.P_HTTP)), 0, false},
+		{Not(TCP), 0, false},
+		{Or(), 0, false},
+	} {
+		n, fixed := ConsumedLen(tc.Pattern)
+		if n != tc.Len || fixed != tc.Fixed {
+			t.Errorf("expected %s to consume (%d, %v) components, got (%d, %v)", tc.Pattern, tc.Len, tc.Fixed, n, fixed)
+		}
+	}
+
+	// A fixed length is the length of every address the pattern matches.
+	for _, tc := range TestVectors {
+		for _, s := range tc.Good {
+			a := ma.StringCast(s)
+			if n, fixed := ConsumedLen(tc.Pattern); fixed && n != len(components(a)) {
+				t.Errorf("%s matches %s but is said to consume %d components", tc.Pattern, s, n)
+			}
+		}
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, p := range []Pattern{Base(ma.P_TCP), TCP, HTTPS, Contains(P2P), Repeat(UDP, 1, 3)} {
+		if c := Clone(p); !c.Equal(p) {
+			t.Errorf("expected clone %s to equal %s", c, p)
+		}
+	}
+
+	orig := TCP.String()
+	c := Clone(TCP).(*pattern)
+	c.Args[0].(*pattern).Args[0] = Base(ma.P_UDP)
+	c.Args[1] = Base(ma.P_UDP)
+	if TCP.String() != orig {
+		t.Fatalf("mutating a clone changed the original to %s", TCP)
+	}
+}